@@ -0,0 +1,133 @@
+package bitpacker
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Filter decides what EncodeFiltered sends to one client. obj is a pointer
+// into the original message (the message itself, a nested class or an array
+// element) and field is the schema field name, as in the json tag.
+type Filter struct {
+	// Object reports whether a nested object is sent. Excluded array
+	// elements are removed; an excluded class-typed field is zeroed.
+	Object func(obj any) bool
+	// Field reports whether a field of obj is sent. Excluded fields are
+	// encoded as their zero value.
+	Field func(obj any, field string) bool
+}
+
+// Mask returns a Filter that hides fields by class name:
+//
+//	bitpacker.Mask(map[string][]string{"Character": {"inventory", "is_alive"}})
+//
+// Names are matched ignoring case and underscores, so the schema name also
+// hides the field of a struct without json tags ("is_alive" matches
+// IsAlive).
+func Mask(hidden map[string][]string) Filter {
+	byClass := make(map[string][]string, len(hidden))
+	for class, fields := range hidden {
+		for _, field := range fields {
+			byClass[foldName(class)] = append(byClass[foldName(class)], foldName(field))
+		}
+	}
+	return Filter{
+		Field: func(obj any, field string) bool {
+			return !slices.Contains(byClass[foldName(reflect.TypeOf(obj).Elem().Name())], foldName(field))
+		},
+	}
+}
+
+func foldName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// EncodeFiltered encodes a copy of msg with the parts rejected by f removed
+// or zeroed. The result has the normal wire format, so the client decodes it
+// with the usual Decode<T>. msg itself is not modified.
+func EncodeFiltered(msg Encoder, f Filter) []byte {
+	src := reflect.ValueOf(msg).Elem()
+	dst := reflect.New(src.Type())
+	filterStruct(dst.Elem(), src, f)
+	return dst.Interface().(Encoder).Encode()
+}
+
+func filterStruct(dst, src reflect.Value, f Filter) {
+	obj := src.Addr().Interface()
+	t := src.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if f.Field != nil && !f.Field(obj, schemaName(sf)) {
+			continue
+		}
+		filterValue(dst.Field(i), src.Field(i), f)
+	}
+}
+
+func filterValue(dst, src reflect.Value, f Filter) {
+	switch src.Kind() {
+	case reflect.Struct:
+		if f.Object != nil && !f.Object(src.Addr().Interface()) {
+			return
+		}
+		filterStruct(dst, src, f)
+	case reflect.Pointer:
+		if src.IsNil() || src.Elem().Kind() != reflect.Struct {
+			dst.Set(src)
+			return
+		}
+		if f.Object != nil && !f.Object(src.Interface()) {
+			return
+		}
+		dst.Set(reflect.New(src.Elem().Type()))
+		filterStruct(dst.Elem(), src.Elem(), f)
+	case reflect.Slice:
+		elem := src.Type().Elem()
+		ptr := elem.Kind() == reflect.Pointer
+		if ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			dst.Set(src)
+			return
+		}
+		out := reflect.MakeSlice(src.Type(), 0, src.Len())
+		for j := 0; j < src.Len(); j++ {
+			el := src.Index(j)
+			if ptr {
+				if el.IsNil() {
+					out = reflect.Append(out, el)
+					continue
+				}
+				el = el.Elem()
+			}
+			if f.Object != nil && !f.Object(el.Addr().Interface()) {
+				continue
+			}
+			n := reflect.New(elem)
+			filterStruct(n.Elem(), el, f)
+			if !ptr {
+				n = n.Elem()
+			}
+			out = reflect.Append(out, n)
+		}
+		dst.Set(out)
+	default:
+		dst.Set(src)
+	}
+}
+
+// schemaName is the .buff field name, which the generator writes as the
+// json tag.
+func schemaName(sf reflect.StructField) string {
+	if tag, ok := sf.Tag.Lookup("json"); ok {
+		if name, _, _ := strings.Cut(tag, ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
@@ -0,0 +1,149 @@
+package bitpacker_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	gen "bit-parser/generated/go_bench/go"
+	"bit-parser/runtime/bitpacker"
+)
+
+// The generator writes the schema name as a json tag in some outputs and
+// leaves it out in others (generated/go/*_structs.go); both shapes are
+// covered. Encode uses JSON so the tests can read the result back.
+type item struct {
+	ID   int32
+	Name string
+}
+
+type character struct {
+	Name      string
+	IsAlive   bool
+	Mp        int32
+	Inventory []item
+	Pets      []*item
+}
+
+func (c *character) Encode() []byte {
+	data, _ := json.Marshal(c)
+	return data
+}
+
+type taggedCharacter struct {
+	Name    string `json:"name"`
+	IsAlive bool   `json:"is_alive"`
+	Mp      int32  `json:"mp"`
+}
+
+func (c *taggedCharacter) Encode() []byte {
+	data, _ := json.Marshal(c)
+	return data
+}
+
+func TestMaskUntagged(t *testing.T) {
+	src := &character{Name: "Ayla", IsAlive: true, Mp: 30, Inventory: []item{{ID: 1}}}
+	f := bitpacker.Mask(map[string][]string{"character": {"is_alive", "inventory", "mp"}})
+	var got character
+	json.Unmarshal(bitpacker.EncodeFiltered(src, f), &got)
+	if got.Name != "Ayla" || got.IsAlive || got.Mp != 0 || got.Inventory != nil {
+		t.Fatalf("got %+v", got)
+	}
+	if !src.IsAlive || src.Mp != 30 || len(src.Inventory) != 1 {
+		t.Fatalf("source modified: %+v", src)
+	}
+}
+
+func TestMaskTagged(t *testing.T) {
+	src := &taggedCharacter{Name: "Ayla", IsAlive: true, Mp: 30}
+	f := bitpacker.Mask(map[string][]string{"taggedCharacter": {"is_alive"}})
+	var got taggedCharacter
+	json.Unmarshal(bitpacker.EncodeFiltered(src, f), &got)
+	if got != (taggedCharacter{Name: "Ayla", Mp: 30}) {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestObjectFilter(t *testing.T) {
+	src := &character{
+		Inventory: []item{{ID: 1}, {ID: 2}, {ID: 3}},
+		Pets:      []*item{{ID: 4}, {ID: 5}, nil, {ID: 6}},
+	}
+	calls := map[int32]int{}
+	f := bitpacker.Filter{
+		Object: func(obj any) bool {
+			it, ok := obj.(*item)
+			if !ok {
+				return true
+			}
+			calls[it.ID]++
+			return it.ID%2 == 0
+		},
+		Field: func(obj any, field string) bool {
+			_, ok := obj.(*item)
+			return !ok || field != "Name"
+		},
+	}
+	src.Pets[0].Name = "Rex"
+	var got character
+	json.Unmarshal(bitpacker.EncodeFiltered(src, f), &got)
+
+	if len(got.Inventory) != 1 || got.Inventory[0].ID != 2 {
+		t.Errorf("Inventory %+v", got.Inventory)
+	}
+	if len(got.Pets) != 3 || got.Pets[0].ID != 4 || got.Pets[0].Name != "" || got.Pets[1] != nil || got.Pets[2].ID != 6 {
+		t.Errorf("Pets %+v", got.Pets)
+	}
+	for id, n := range calls {
+		if n != 1 {
+			t.Errorf("Object called %d times for item %d", n, id)
+		}
+	}
+	if src.Pets[0].Name != "Rex" {
+		t.Errorf("source modified")
+	}
+}
+
+func TestEncodeFilteredGenerated(t *testing.T) {
+	hero := gen.Character{
+		Name: "Ayla", Level: 12, Hp: 90, Mp: 30, Is_alive: true,
+		Position:  gen.Vec3{X: 1, Y: 2, Z: 3},
+		Skills:    []int32{4, 5},
+		Inventory: []gen.Item{{Id: 1, Name: "sword", Rarity: "rare"}, {Id: 2, Name: "map"}},
+	}
+	src := &gen.WorldState{
+		World_id:   9,
+		Seed:       "secret",
+		Guilds:     []gen.Guild{{Name: "Dawn", Members: []gen.Character{hero}}},
+		Loot_table: []gen.Item{{Id: 3, Name: "gem", Value: 500}},
+	}
+	f := bitpacker.Mask(map[string][]string{
+		"WorldState": {"seed"},
+		"Character":  {"hp", "position"},
+		"Item":       {"rarity"},
+	})
+	f.Object = func(obj any) bool {
+		it, ok := obj.(*gen.Item)
+		return !ok || it.Name != "map"
+	}
+
+	got, err := gen.DecodeWorldState(bitpacker.EncodeFiltered(src, f))
+	if err != nil {
+		t.Fatalf("filtered payload does not decode: %v", err)
+	}
+	if got.World_id != 9 || got.Seed != "" || len(got.Loot_table) != 1 || got.Loot_table[0] != (gen.Item{Id: 3, Name: "gem", Value: 500}) {
+		t.Fatalf("world %+v", got)
+	}
+	if len(got.Guilds) != 1 || len(got.Guilds[0].Members) != 1 {
+		t.Fatalf("guilds %+v", got.Guilds)
+	}
+	c := got.Guilds[0].Members[0]
+	if c.Name != "Ayla" || c.Level != 12 || c.Hp != 0 || c.Mp != 30 || !c.Is_alive || c.Position != (gen.Vec3{}) || len(c.Skills) != 2 {
+		t.Errorf("member %+v", c)
+	}
+	if len(c.Inventory) != 1 || c.Inventory[0] != (gen.Item{Id: 1, Name: "sword"}) {
+		t.Errorf("inventory %+v", c.Inventory)
+	}
+	if src.Seed != "secret" || src.Guilds[0].Members[0].Hp != 90 || len(src.Guilds[0].Members[0].Inventory) != 2 {
+		t.Errorf("source modified")
+	}
+}
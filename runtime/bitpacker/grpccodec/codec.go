@@ -0,0 +1,59 @@
+// Package grpccodec lets grpc-go carry generated BitPacker types instead of
+// protobuf. It lives in its own module so the root module stays free of the
+// grpc dependency.
+//
+//	reg := bitpacker.NewRegistry()
+//	bitpacker.Register(reg, 1, gen.DecodeWorldState)
+//	grpccodec.Register(reg)
+//
+//	conn, _ := grpc.NewClient(addr,
+//		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(grpccodec.Name)))
+package grpccodec
+
+import (
+	"fmt"
+
+	"bit-parser/runtime/bitpacker"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the content-subtype the codec is registered under
+// ("application/grpc+bitpacker" on the wire).
+const Name = "bitpacker"
+
+// Codec implements grpc's encoding.Codec for generated types. Marshal uses
+// Encode() directly; Unmarshal needs the type's Decode<T> from the Registry.
+type Codec struct {
+	reg *bitpacker.Registry
+}
+
+func New(reg *bitpacker.Registry) *Codec {
+	return &Codec{reg: reg}
+}
+
+// Register installs a Codec for reg as grpc's global "bitpacker" codec. Like
+// encoding.RegisterCodec it must be called during initialization.
+func Register(reg *bitpacker.Registry) {
+	encoding.RegisterCodec(New(reg))
+}
+
+func (c *Codec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(bitpacker.Encoder)
+	if !ok {
+		return nil, fmt.Errorf("grpccodec: %T is not a bitpacker message", v)
+	}
+	return msg.Encode(), nil
+}
+
+func (c *Codec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(bitpacker.Encoder)
+	if !ok {
+		return fmt.Errorf("grpccodec: %T is not a bitpacker message", v)
+	}
+	return c.reg.DecodeInto(data, msg)
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
@@ -0,0 +1,86 @@
+package grpccodec_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	gen "bit-parser/generated/go_bench/go"
+	"bit-parser/runtime/bitpacker"
+	"bit-parser/runtime/bitpacker/grpccodec"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// geoService is what protoc-gen-go-grpc would emit for
+//
+//	service Geo { rpc Mirror(Vec3) returns (Vec3); }
+var geoService = grpc.ServiceDesc{
+	ServiceName: "test.Geo",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Mirror",
+		Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			var in gen.Vec3
+			if err := dec(&in); err != nil {
+				return nil, err
+			}
+			return &gen.Vec3{X: in.Y, Y: in.X}, nil
+		},
+	}},
+}
+
+func TestUnaryCallOverCodec(t *testing.T) {
+	reg := bitpacker.NewRegistry()
+	if err := bitpacker.Register(reg, 1, gen.DecodeVec3); err != nil {
+		t.Fatal(err)
+	}
+	grpccodec.Register(reg)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	srv.RegisterService(&geoService, struct{}{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var out gen.Vec3
+	err = conn.Invoke(context.Background(), "/test.Geo/Mirror", &gen.Vec3{X: 3, Y: -7}, &out,
+		grpc.CallContentSubtype(grpccodec.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != (gen.Vec3{X: -7, Y: 3}) {
+		t.Fatalf("got %+v", out)
+	}
+}
+
+func TestUnmarshalUnregistered(t *testing.T) {
+	reg := bitpacker.NewRegistry()
+	bitpacker.Register(reg, 1, gen.DecodeVec3)
+	c := grpccodec.New(reg)
+
+	data, err := c.Marshal(&gen.Item{Name: "sword"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unmarshal(data, &gen.Item{}); !errors.Is(err, bitpacker.ErrUnregistered) {
+		t.Fatalf("got %v, want ErrUnregistered", err)
+	}
+	if err := c.Unmarshal(data, struct{}{}); err == nil {
+		t.Fatal("Unmarshal into a non-message succeeded")
+	}
+}
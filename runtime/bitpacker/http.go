@@ -0,0 +1,157 @@
+package bitpacker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type for Encode() payloads. Responses carry the
+// schema version as a parameter: "application/x-bitpacker; version=1.0.0".
+const ContentType = "application/x-bitpacker"
+
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// WriteResponse encodes msg as BitPacker when the request's Accept header
+// ranks ContentType at least as high as JSON, and as JSON (via the
+// generated json tags) otherwise.
+func WriteResponse(w http.ResponseWriter, r *http.Request, msg Encoder) error {
+	if acceptsBitPacker(r.Header.Get("Accept")) {
+		data := msg.Encode()
+		ct := ContentType
+		if v, ok := payloadVersion(data); ok {
+			ct = mime.FormatMediaType(ContentType, map[string]string{"version": v})
+		}
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, err := w.Write(data)
+		return err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadRequest decodes the request body with the generated Decode<T> function
+// when it is sent as ContentType, and as JSON when it is sent as
+// application/json (or without a Content-Type):
+//
+//	world, err := bitpacker.ReadRequest(r, gen.DecodeWorldState)
+//
+// A version parameter on the Content-Type must match the version the payload
+// starts with; the schema version itself is checked by Decode<T>.
+func ReadRequest[T any, P interface {
+	*T
+	Encoder
+}](r *http.Request, decode func([]byte) (P, error)) (P, error) {
+	mediaType := "application/json"
+	var params map[string]string
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		mediaType, params, err = mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedMediaType, err)
+		}
+	}
+	if mediaType != ContentType && mediaType != "application/json" {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType == ContentType {
+		version, ok := payloadVersion(data)
+		if !ok {
+			return nil, ErrShortFrame
+		}
+		if want, ok := params["version"]; ok && want != version {
+			return nil, fmt.Errorf("%w: Content-Type has version %s but the payload has %s", ErrUnsupportedMediaType, want, version)
+		}
+		return decode(data)
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// MaxBytes rejects requests whose body is larger than limit before any
+// handler reads it: a declared Content-Length over the limit gets 413
+// straight away, and the body is wrapped in http.MaxBytesReader so chunked
+// uploads fail in ReadRequest once they cross it.
+func MaxBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// StatusCode maps an error from ReadRequest to the HTTP status a handler
+// should answer with.
+func StatusCode(err error) int {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// acceptsBitPacker reports whether the Accept header prefers BitPacker to
+// JSON. JSON is the fallback, so BitPacker must be listed explicitly with a
+// q at least as high as the best range that also covers JSON.
+func acceptsBitPacker(accept string) bool {
+	bitpackerQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+		switch mediaType {
+		case ContentType:
+			bitpackerQ = max(bitpackerQ, q)
+		case "application/json", "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return bitpackerQ > 0 && bitpackerQ >= jsonQ
+}
+
+// payloadVersion reads the version string every Encode() starts with
+// (a ZigZag varint length followed by the bytes).
+func payloadVersion(data []byte) (string, bool) {
+	u, n := binary.Uvarint(data)
+	if n <= 0 {
+		return "", false
+	}
+	l := int64(u>>1) ^ -int64(u&1)
+	if l < 0 || int64(len(data)-n) < l {
+		return "", false
+	}
+	return string(data[n : n+int(l)]), true
+}
@@ -0,0 +1,140 @@
+package bitpacker_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gen "bit-parser/generated/go_bench/go"
+	"bit-parser/runtime/bitpacker"
+)
+
+// echo answers with the request's Vec3, limited to 64-byte bodies.
+var echo = bitpacker.MaxBytes(64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	p, err := bitpacker.ReadRequest(r, gen.DecodeVec3)
+	if err != nil {
+		http.Error(w, err.Error(), bitpacker.StatusCode(err))
+		return
+	}
+	bitpacker.WriteResponse(w, r, p)
+}))
+
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	echo.ServeHTTP(w, r)
+	return w
+}
+
+func TestNegotiation(t *testing.T) {
+	body := (&gen.Vec3{X: 4, Y: -2}).Encode()
+	cases := []struct {
+		accept string
+		ct     string
+	}{
+		{"application/x-bitpacker", "application/x-bitpacker; version=1.0.0"},
+		{"application/json, application/x-bitpacker;q=0.5", "application/json"},
+		{"application/json;q=0.5, application/x-bitpacker", "application/x-bitpacker; version=1.0.0"},
+		{"application/x-bitpacker, */*", "application/x-bitpacker; version=1.0.0"},
+		{"application/x-bitpacker;q=0.8, application/*", "application/json"},
+		{"application/x-bitpacker;q=0, application/json", "application/json"},
+		{"*/*", "application/json"},
+		{"", "application/json"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-bitpacker; version=1.0.0")
+		r.Header.Set("Accept", tc.accept)
+		w := serve(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Accept %q: status %d: %s", tc.accept, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Content-Type"); got != tc.ct {
+			t.Errorf("Accept %q: Content-Type %q, want %q", tc.accept, got, tc.ct)
+		}
+		if tc.ct == "application/json" {
+			if got := w.Body.String(); got != `{"x":4,"y":-2,"z":0}` {
+				t.Errorf("Accept %q: body %s", tc.accept, got)
+			}
+		} else if !bytes.Equal(w.Body.Bytes(), body) {
+			t.Errorf("Accept %q: body %x, want %x", tc.accept, w.Body.Bytes(), body)
+		}
+	}
+}
+
+func TestReadRequestJSON(t *testing.T) {
+	for _, ct := range []string{"", "application/json", "application/json; charset=utf-8"} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"x":7,"y":8}`))
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+		r.Header.Set("Accept", "application/json")
+		w := serve(r)
+		var p gen.Vec3
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &p) != nil || p != (gen.Vec3{X: 7, Y: 8}) {
+			t.Errorf("Content-Type %q: status %d, body %s", ct, w.Code, w.Body)
+		}
+	}
+}
+
+func TestReadRequestVersion(t *testing.T) {
+	body := (&gen.Vec3{X: 1}).Encode()
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-bitpacker; version=2.0.0")
+	_, err := bitpacker.ReadRequest(r, gen.DecodeVec3)
+	if !errors.Is(err, bitpacker.ErrUnsupportedMediaType) || !strings.Contains(err.Error(), "2.0.0") {
+		t.Fatalf("got %v, want a version mismatch", err)
+	}
+	r = httptest.NewRequest("POST", "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-bitpacker; version=2.0.0")
+	if w := serve(r); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status %d, want 415", w.Code)
+	}
+}
+
+func TestUnsupportedMediaType(t *testing.T) {
+	for _, ct := range []string{"text/plain", "application/x-bitpacker; version"} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader("hello"))
+		r.Header.Set("Content-Type", ct)
+		if w := serve(r); w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: status %d, want 415", ct, w.Code)
+		}
+	}
+}
+
+// chunked hides the length of its reader, so the request has no
+// Content-Length.
+type chunked struct{ io.Reader }
+
+func TestMaxBytes(t *testing.T) {
+	big := bytes.Repeat([]byte(" "), 100)
+	big = append(big, `{"x":1}`...)
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(big))
+	r.Header.Set("Content-Type", "application/json")
+	if r.ContentLength != int64(len(big)) {
+		t.Fatalf("ContentLength %d", r.ContentLength)
+	}
+	if w := serve(r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Content-Length: status %d, want 413", w.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/", chunked{bytes.NewReader(big)})
+	r.Header.Set("Content-Type", "application/json")
+	if r.ContentLength != -1 {
+		t.Fatalf("ContentLength %d, want unknown", r.ContentLength)
+	}
+	if w := serve(r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked: status %d, want 413", w.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/", chunked{strings.NewReader(`{"x":1}`)})
+	r.Header.Set("Content-Type", "application/json")
+	if w := serve(r); w.Code != http.StatusOK {
+		t.Errorf("small chunked body: status %d", w.Code)
+	}
+}
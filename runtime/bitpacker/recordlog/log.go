@@ -0,0 +1,381 @@
+// Package recordlog is an append-only log of BitPacker records on disk.
+// Records are numbered from 0 and stored with their length, a CRC32 and a
+// type ID (the bitpacker.Registry ID when written with AppendMessage) in
+// size-capped segment files, each with a sparse offset index.
+//
+// On Open the newest segment is scanned from its last index entry, and a
+// record torn by a crash (or anything after it that fails its checksum) is
+// truncated away.
+package recordlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"bit-parser/runtime/bitpacker"
+)
+
+var (
+	ErrNotFound = errors.New("recordlog: record not found")
+	ErrCorrupt  = errors.New("recordlog: corrupt record")
+	ErrClosed   = errors.New("recordlog: log closed")
+	ErrTooLarge = errors.New("recordlog: record too large")
+)
+
+type Options struct {
+	// SegmentSize starts a new segment once the active one would grow
+	// past it. A record larger than SegmentSize gets a segment of its
+	// own. Default 64 MiB.
+	SegmentSize int64
+	// IndexInterval is the number of records between index entries.
+	// Default 64.
+	IndexInterval int
+	// Sync fsyncs the segment after every Append.
+	Sync bool
+}
+
+// Record is one entry of the log. Data is owned by the caller.
+type Record struct {
+	Seq  uint64
+	Type uint32
+	Data []byte
+}
+
+// Decode decodes the record with the generated Decode<T> registered for
+// its type.
+func (r Record) Decode(reg *bitpacker.Registry) (bitpacker.Encoder, error) {
+	return reg.Decode(r.Type, r.Data)
+}
+
+// Log is safe for concurrent use.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu       sync.RWMutex
+	segments []*segment
+	closed   bool
+}
+
+// Open opens or creates the log in dir.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 64 << 20
+	}
+	if opts.IndexInterval <= 0 {
+		opts.IndexInterval = 64
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	created := len(bases) == 0
+	if created {
+		bases = []uint64{0}
+	}
+	l := &Log{dir: dir, opts: opts}
+	for i, base := range bases {
+		s, err := openSegment(dir, base)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.segments = append(l.segments, s)
+		if i < len(bases)-1 {
+			s.count = bases[i+1] - base
+		}
+	}
+	if created {
+		if err := syncDir(dir); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if err := l.active().recover(opts.IndexInterval); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok {
+			continue
+		}
+		base, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
+
+func (l *Log) active() *segment {
+	return l.segments[len(l.segments)-1]
+}
+
+// FirstSeq is the sequence number of the oldest record.
+func (l *Log) FirstSeq() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[0].base
+}
+
+// NextSeq is the sequence number the next Append will get.
+func (l *Log) NextSeq() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	a := l.active()
+	return a.base + a.count
+}
+
+// Append writes a record and returns its sequence number. With
+// Options.Sync, a failed fsync is returned together with the sequence
+// number: the record has been written and is readable, but may not survive
+// a crash.
+func (l *Log) Append(typ uint32, data []byte) (uint64, error) {
+	if len(data) > math.MaxUint32 {
+		return 0, ErrTooLarge
+	}
+	rec := make([]byte, recordHeaderSize, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(rec[0:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[8:], typ)
+	binary.LittleEndian.PutUint32(rec[4:], recordCRC(rec[8:12], data))
+	rec = append(rec, data...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	s := l.active()
+	if s.size > 0 && s.size+int64(len(rec)) > l.opts.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+		s = l.active()
+	}
+	pos := s.size
+	if _, err := s.log.WriteAt(rec, pos); err != nil {
+		s.log.Truncate(pos)
+		return 0, err
+	}
+	rel := uint32(s.count)
+	if rel%uint32(l.opts.IndexInterval) == 0 {
+		if err := s.appendIndex(indexEntry{rel: rel, pos: pos}); err != nil {
+			s.log.Truncate(pos)
+			return 0, err
+		}
+	}
+	s.size += int64(len(rec))
+	s.count++
+	seq := s.base + uint64(rel)
+	if l.opts.Sync {
+		if err := s.sync(); err != nil {
+			return seq, err
+		}
+	}
+	return seq, nil
+}
+
+// AppendMessage appends msg.Encode() under the ID reg has for its type.
+func (l *Log) AppendMessage(reg *bitpacker.Registry, msg bitpacker.Encoder) (uint64, error) {
+	id, ok := reg.ID(msg)
+	if !ok {
+		return 0, fmt.Errorf("%w: %T", bitpacker.ErrUnregistered, msg)
+	}
+	return l.Append(id, msg.Encode())
+}
+
+func (l *Log) rotate() error {
+	s := l.active()
+	if err := s.sync(); err != nil {
+		return err
+	}
+	next, err := openSegment(l.dir, s.base+s.count)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		next.close()
+		return err
+	}
+	l.segments = append(l.segments, next)
+	return nil
+}
+
+// ReadAt returns record seq.
+func (l *Log) ReadAt(seq uint64) (Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return Record{}, ErrClosed
+	}
+	s, pos, err := l.find(seq)
+	if err != nil {
+		return Record{}, err
+	}
+	typ, data, _, err := s.readRecord(pos, true)
+	if err != nil {
+		return Record{}, fmt.Errorf("%w: seq %d", err, seq)
+	}
+	return Record{Seq: seq, Type: typ, Data: data}, nil
+}
+
+func (l *Log) find(seq uint64) (*segment, int64, error) {
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].base > seq }) - 1
+	if i < 0 || seq-l.segments[i].base >= l.segments[i].count {
+		return nil, 0, fmt.Errorf("%w: seq %d", ErrNotFound, seq)
+	}
+	s := l.segments[i]
+	pos, err := s.locate(uint32(seq - s.base))
+	return s, pos, err
+}
+
+// Forward iterates from record from to the last record present when
+// iteration starts. It stops at the first error, which it yields.
+func (l *Log) Forward(from uint64) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		end := l.NextSeq()
+		var s *segment
+		var pos int64
+		for seq := from; seq < end; seq++ {
+			l.mu.RLock()
+			rec, next, err := l.readNext(&s, seq, pos)
+			l.mu.RUnlock()
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if !yield(rec, nil) {
+				return
+			}
+			pos = next
+		}
+	}
+}
+
+// readNext reads record seq at pos in *s, first locating it when seq is
+// not in *s.
+func (l *Log) readNext(s **segment, seq uint64, pos int64) (Record, int64, error) {
+	if l.closed {
+		return Record{}, 0, ErrClosed
+	}
+	if *s == nil || seq == (*s).base+(*s).count {
+		var err error
+		if *s, pos, err = l.find(seq); err != nil {
+			return Record{}, 0, err
+		}
+	}
+	typ, data, next, err := (*s).readRecord(pos, true)
+	if err != nil {
+		return Record{}, 0, fmt.Errorf("%w: seq %d", err, seq)
+	}
+	return Record{Seq: seq, Type: typ, Data: data}, next, nil
+}
+
+// Backward iterates from record from (clamped to the last record) down to
+// the first record. Records are read one index interval at a time and
+// yielded in reverse.
+func (l *Log) Backward(from uint64) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		first, end := l.FirstSeq(), l.NextSeq()
+		if end == first {
+			return
+		}
+		from = min(from, end-1)
+		var block []Record
+		for seq := int64(from); seq >= int64(first); seq = int64(block[0].Seq) - 1 {
+			var err error
+			l.mu.RLock()
+			block, err = l.readBlock(uint64(seq), block[:0])
+			l.mu.RUnlock()
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			for i := len(block) - 1; i >= 0; i-- {
+				if !yield(block[i], nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// readBlock reads the records from the index entry at or before seq up to
+// and including seq.
+func (l *Log) readBlock(seq uint64, block []Record) ([]Record, error) {
+	if l.closed {
+		return nil, ErrClosed
+	}
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].base > seq }) - 1
+	if i < 0 || seq-l.segments[i].base >= l.segments[i].count {
+		return nil, fmt.Errorf("%w: seq %d", ErrNotFound, seq)
+	}
+	s := l.segments[i]
+	rel := uint32(seq - s.base)
+	var cur uint32
+	var pos int64
+	if j := sort.Search(len(s.index), func(j int) bool { return s.index[j].rel > rel }); j > 0 {
+		cur, pos = s.index[j-1].rel, s.index[j-1].pos
+	}
+	for ; cur <= rel; cur++ {
+		typ, data, next, err := s.readRecord(pos, true)
+		if err != nil {
+			return nil, fmt.Errorf("%w: seq %d", err, s.base+uint64(cur))
+		}
+		block = append(block, Record{Seq: s.base + uint64(cur), Type: typ, Data: data})
+		pos = next
+	}
+	return block, nil
+}
+
+// Sync flushes the active segment and its index to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return l.active().sync()
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	var err error
+	if len(l.segments) > 0 {
+		err = l.active().sync()
+	}
+	for _, s := range l.segments {
+		if cerr := s.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
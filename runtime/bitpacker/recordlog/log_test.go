@@ -0,0 +1,227 @@
+package recordlog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func payload(seq uint64) []byte {
+	return []byte(fmt.Sprintf("record %02d", seq))
+}
+
+// recordSize is the on-disk size of every test record.
+var recordSize = int64(recordHeaderSize + len(payload(0)))
+
+func fill(t *testing.T, dir string, opts Options, n int) {
+	t.Helper()
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		seq, err := l.Append(uint32(i), payload(uint64(i)))
+		if err != nil || seq != uint64(i) {
+			t.Fatalf("Append %d: seq %d, %v", i, seq, err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// check verifies that the log holds exactly records 0..n-1 and takes a
+// new record as n.
+func check(t *testing.T, l *Log, n uint64) {
+	t.Helper()
+	if got := l.NextSeq(); got != n {
+		t.Fatalf("NextSeq %d, want %d", got, n)
+	}
+	for seq := uint64(0); seq < n; seq++ {
+		rec, err := l.ReadAt(seq)
+		if err != nil || string(rec.Data) != string(payload(seq)) || rec.Type != uint32(seq) {
+			t.Fatalf("ReadAt(%d): %+v, %v", seq, rec, err)
+		}
+	}
+	if _, err := l.ReadAt(n); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ReadAt(%d): %v, want ErrNotFound", n, err)
+	}
+	seq, err := l.Append(uint32(n), payload(n))
+	if err != nil || seq != n {
+		t.Fatalf("Append after recovery: seq %d, %v", seq, err)
+	}
+	if rec, err := l.ReadAt(n); err != nil || string(rec.Data) != string(payload(n)) {
+		t.Fatalf("ReadAt(%d) after Append: %+v, %v", n, rec, err)
+	}
+}
+
+func truncate(t *testing.T, dir string, size int64) {
+	t.Helper()
+	if err := os.Truncate(segmentPath(dir, 0, ".log"), size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverTornHeader(t *testing.T) {
+	dir := t.TempDir()
+	fill(t, dir, Options{IndexInterval: 4}, 10)
+	truncate(t, dir, 9*recordSize+5)
+
+	l, err := Open(dir, Options{IndexInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	check(t, l, 9)
+}
+
+func TestRecoverTornPayload(t *testing.T) {
+	dir := t.TempDir()
+	fill(t, dir, Options{IndexInterval: 4}, 10)
+	truncate(t, dir, 10*recordSize-3)
+
+	l, err := Open(dir, Options{IndexInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	check(t, l, 9)
+}
+
+func corrupt(t *testing.T, dir string, seq int64) {
+	t.Helper()
+	f, err := os.OpenFile(segmentPath(dir, 0, ".log"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte{'X'}, seq*recordSize+recordHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverChecksumMismatch(t *testing.T) {
+	// Open scans from the last index entry (record 8), so a bad record 9
+	// is truncated together with everything after it.
+	dir := t.TempDir()
+	fill(t, dir, Options{IndexInterval: 4}, 11)
+	corrupt(t, dir, 9)
+
+	l, err := Open(dir, Options{IndexInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	check(t, l, 9)
+}
+
+func TestChecksumMismatchBeforeLastIndexEntry(t *testing.T) {
+	// Older records are not rescanned; the bad one is reported on read.
+	dir := t.TempDir()
+	fill(t, dir, Options{IndexInterval: 4}, 10)
+	corrupt(t, dir, 2)
+
+	l, err := Open(dir, Options{IndexInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if got := l.NextSeq(); got != 10 {
+		t.Fatalf("NextSeq %d, want 10", got)
+	}
+	if _, err := l.ReadAt(2); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("ReadAt(2): %v, want ErrCorrupt", err)
+	}
+	if rec, err := l.ReadAt(3); err != nil || string(rec.Data) != string(payload(3)) {
+		t.Fatalf("ReadAt(3): %+v, %v", rec, err)
+	}
+	var seqs []uint64
+	var last error
+	for rec, err := range l.Forward(0) {
+		if err != nil {
+			last = err
+			break
+		}
+		seqs = append(seqs, rec.Seq)
+	}
+	if len(seqs) != 2 || !errors.Is(last, ErrCorrupt) {
+		t.Fatalf("Forward read %v, then %v", seqs, last)
+	}
+}
+
+func TestRecoverIndexPastTruncation(t *testing.T) {
+	dir := t.TempDir()
+	fill(t, dir, Options{IndexInterval: 4}, 10)
+	// Cut records 8 and 9; the index still has an entry for record 8.
+	truncate(t, dir, 8*recordSize)
+
+	l, err := Open(dir, Options{IndexInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []indexEntry{{0, 0}, {4, 4 * recordSize}}; fmt.Sprint(l.active().index) != fmt.Sprint(want) {
+		t.Fatalf("index %v, want %v", l.active().index, want)
+	}
+	check(t, l, 8)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry for the new record 8 points at it.
+	l, err = Open(dir, Options{IndexInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if want := []indexEntry{{0, 0}, {4, 4 * recordSize}, {8, 8 * recordSize}}; fmt.Sprint(l.active().index) != fmt.Sprint(want) {
+		t.Fatalf("index after reopen %v, want %v", l.active().index, want)
+	}
+	check(t, l, 9)
+}
+
+func TestIterateAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{SegmentSize: 3 * recordSize, IndexInterval: 2}
+	fill(t, dir, opts, 10)
+
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if len(l.segments) != 4 {
+		t.Fatalf("%d segments, want 4", len(l.segments))
+	}
+
+	want := uint64(9)
+	n := 0
+	for rec, err := range l.Backward(100) { // clamped to the last record
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Seq != want || string(rec.Data) != string(payload(want)) {
+			t.Fatalf("Backward yielded %d %q, want %d", rec.Seq, rec.Data, want)
+		}
+		want--
+		n++
+	}
+	if n != 10 {
+		t.Fatalf("Backward yielded %d records, want 10", n)
+	}
+
+	want = 2
+	for rec, err := range l.Forward(2) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Seq != want || string(rec.Data) != string(payload(want)) {
+			t.Fatalf("Forward yielded %d %q, want %d", rec.Seq, rec.Data, want)
+		}
+		want++
+	}
+	if want != 10 {
+		t.Fatalf("Forward stopped at %d", want)
+	}
+	check(t, l, 10)
+}
@@ -0,0 +1,230 @@
+package recordlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Record layout (little-endian):
+//
+//	uint32 length   payload bytes
+//	uint32 crc32    Castagnoli, over type id and payload
+//	uint32 type id
+//	payload
+//
+// Each segment NNN.log (NNN = sequence number of its first record) has a
+// sparse index NNN.idx of (uint32 record number within the segment, uint64
+// file offset) pairs, one every Options.IndexInterval records.
+const (
+	recordHeaderSize = 12
+	indexEntrySize   = 12
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type indexEntry struct {
+	rel uint32
+	pos int64
+}
+
+type segment struct {
+	base  uint64
+	count uint64
+	size  int64
+	log   *os.File
+	idx   *os.File
+	index []indexEntry
+}
+
+func segmentPath(dir string, base uint64, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, ext))
+}
+
+func openSegment(dir string, base uint64) (*segment, error) {
+	log, err := os.OpenFile(segmentPath(dir, base, ".log"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := os.OpenFile(segmentPath(dir, base, ".idx"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	s := &segment{base: base, log: log, idx: idx}
+	if err := s.loadIndex(); err != nil {
+		s.close()
+		return nil, err
+	}
+	fi, err := log.Stat()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	s.size = fi.Size()
+	return s, nil
+}
+
+func (s *segment) loadIndex() error {
+	data, err := io.ReadAll(io.NewSectionReader(s.idx, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	s.index = s.index[:0]
+	for len(data) >= indexEntrySize {
+		s.index = append(s.index, indexEntry{
+			rel: binary.LittleEndian.Uint32(data),
+			pos: int64(binary.LittleEndian.Uint64(data[4:])),
+		})
+		data = data[indexEntrySize:]
+	}
+	return nil
+}
+
+func (s *segment) writeIndex() error {
+	buf := make([]byte, 0, len(s.index)*indexEntrySize)
+	for _, e := range s.index {
+		buf = binary.LittleEndian.AppendUint32(buf, e.rel)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.pos))
+	}
+	if err := s.idx.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.idx.WriteAt(buf, 0)
+	return err
+}
+
+func (s *segment) appendIndex(e indexEntry) error {
+	var buf [indexEntrySize]byte
+	binary.LittleEndian.PutUint32(buf[:], e.rel)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(e.pos))
+	if _, err := s.idx.WriteAt(buf[:], int64(len(s.index))*indexEntrySize); err != nil {
+		return err
+	}
+	s.index = append(s.index, e)
+	return nil
+}
+
+// recover validates the records of the active segment, truncating at the
+// first torn or corrupt one, and brings the index back in line with what
+// survived. Scanning resumes from the last index entry that still points
+// at a valid record.
+func (s *segment) recover(interval int) error {
+	for len(s.index) > 0 {
+		last := s.index[len(s.index)-1]
+		if _, _, _, err := s.readRecord(last.pos, true); err == nil {
+			break
+		}
+		s.index = s.index[:len(s.index)-1]
+	}
+	var rel uint32
+	var pos int64
+	if len(s.index) > 0 {
+		rel, pos = s.index[len(s.index)-1].rel, s.index[len(s.index)-1].pos
+	}
+	for pos < s.size {
+		_, _, next, err := s.readRecord(pos, true)
+		if err != nil {
+			break
+		}
+		if rel%uint32(interval) == 0 && (len(s.index) == 0 || s.index[len(s.index)-1].rel < rel) {
+			s.index = append(s.index, indexEntry{rel: rel, pos: pos})
+		}
+		rel++
+		pos = next
+	}
+	if pos < s.size {
+		if err := s.log.Truncate(pos); err != nil {
+			return err
+		}
+		s.size = pos
+	}
+	s.count = uint64(rel)
+	return s.writeIndex()
+}
+
+// readRecord reads the record at pos and returns its type, payload and the
+// offset of the next record. With verify false only the header is read and
+// data is nil.
+func (s *segment) readRecord(pos int64, verify bool) (typ uint32, data []byte, next int64, err error) {
+	var hdr [recordHeaderSize]byte
+	if pos+recordHeaderSize > s.size {
+		return 0, nil, 0, ErrCorrupt
+	}
+	if _, err := s.log.ReadAt(hdr[:], pos); err != nil {
+		return 0, nil, 0, err
+	}
+	length := int64(binary.LittleEndian.Uint32(hdr[0:]))
+	sum := binary.LittleEndian.Uint32(hdr[4:])
+	typ = binary.LittleEndian.Uint32(hdr[8:])
+	next = pos + recordHeaderSize + length
+	if next > s.size {
+		return 0, nil, 0, ErrCorrupt
+	}
+	if !verify {
+		return typ, nil, next, nil
+	}
+	data = make([]byte, length)
+	if _, err := s.log.ReadAt(data, pos+recordHeaderSize); err != nil {
+		return 0, nil, 0, err
+	}
+	if recordCRC(hdr[8:], data) != sum {
+		return 0, nil, 0, ErrCorrupt
+	}
+	return typ, data, next, nil
+}
+
+// locate returns the offset of record rel, scanning forward from the
+// nearest index entry (or the start of the segment if there is none).
+func (s *segment) locate(rel uint32) (int64, error) {
+	var cur uint32
+	var pos int64
+	if i := sort.Search(len(s.index), func(i int) bool { return s.index[i].rel > rel }); i > 0 {
+		cur, pos = s.index[i-1].rel, s.index[i-1].pos
+	}
+	for cur < rel {
+		_, _, next, err := s.readRecord(pos, false)
+		if err != nil {
+			return 0, err
+		}
+		cur++
+		pos = next
+	}
+	return pos, nil
+}
+
+func (s *segment) sync() error {
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	return s.idx.Sync()
+}
+
+// syncDir makes the creation of new segment files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *segment) close() error {
+	err := s.log.Close()
+	if ierr := s.idx.Close(); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+func recordCRC(typ []byte, data []byte) uint32 {
+	return crc32.Update(crc32.Checksum(typ, crcTable), crcTable, data)
+}
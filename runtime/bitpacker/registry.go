@@ -0,0 +1,159 @@
+// Package bitpacker holds optional Go runtime helpers for code produced by
+// the bitpacker generator. Generated code stays self-contained; nothing in
+// here is required to Encode or Decode a message.
+package bitpacker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+)
+
+// Encoder is satisfied by every generated Go type: Encode writes the schema
+// version followed by the fields. The name Message is kept for the wider
+// EncodeTo/DecodeFrom/Size/TypeName interface once the generator emits it.
+type Encoder interface {
+	Encode() []byte
+}
+
+var (
+	ErrUnknownType  = errors.New("unknown message type")
+	ErrDuplicateID  = errors.New("message id already registered")
+	ErrShortFrame   = errors.New("buffer underflow")
+	ErrUnregistered = errors.New("message type not registered")
+)
+
+type registryEntry struct {
+	typ    reflect.Type
+	name   string
+	decode func([]byte) (Encoder, error)
+}
+
+// Registry maps numeric message IDs to generated Decode<T> functions so a
+// single connection can carry many message types. A Registry is safe for
+// concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	byID   map[uint32]registryEntry
+	byType map[reflect.Type]uint32
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byID:   make(map[uint32]registryEntry),
+		byType: make(map[reflect.Type]uint32),
+	}
+}
+
+// StableID derives an ID from a class name (FNV-1a), for schemas that do not
+// assign IDs by hand. It only changes when the class is renamed.
+func StableID(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
+
+// Register binds id to a generated type through its Decode<T> function:
+//
+//	bitpacker.Register(reg, 12, gen.DecodeWorldState)
+func Register[T any, P interface {
+	*T
+	Encoder
+}](r *Registry, id uint32, decode func([]byte) (P, error)) error {
+	typ := reflect.TypeFor[P]()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.byID[id]; ok {
+		return fmt.Errorf("%w: %d (%s)", ErrDuplicateID, id, prev.name)
+	}
+	if prev, ok := r.byType[typ]; ok {
+		return fmt.Errorf("%w: %s as %d", ErrDuplicateID, typ.Elem().Name(), prev)
+	}
+	r.byID[id] = registryEntry{
+		typ:  typ,
+		name: typ.Elem().Name(),
+		decode: func(data []byte) (Encoder, error) {
+			v, err := decode(data)
+			if err != nil {
+				return nil, err
+			}
+			return v, nil
+		},
+	}
+	r.byType[typ] = id
+	return nil
+}
+
+// RegisterStable is Register with the ID taken from StableID(type name).
+func RegisterStable[T any, P interface {
+	*T
+	Encoder
+}](r *Registry, decode func([]byte) (P, error)) (uint32, error) {
+	id := StableID(reflect.TypeFor[T]().Name())
+	return id, Register(r, id, decode)
+}
+
+// ID returns the ID registered for msg's type.
+func (r *Registry) ID(msg Encoder) (uint32, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.byType[reflect.TypeOf(msg)]
+	return id, ok
+}
+
+// Decode decodes a payload produced by Encode() for the type registered as id.
+func (r *Registry) Decode(id uint32, data []byte) (Encoder, error) {
+	r.mu.RLock()
+	e, ok := r.byID[id]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownType, id)
+	}
+	return e.decode(data)
+}
+
+// DecodeInto decodes data into msg, which must be a pointer to a registered
+// generated type. It is the shape expected by Unmarshal-style APIs.
+func (r *Registry) DecodeInto(data []byte, msg Encoder) error {
+	r.mu.RLock()
+	id, ok := r.byType[reflect.TypeOf(msg)]
+	e := r.byID[id]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrUnregistered, msg)
+	}
+	v, err := e.decode(data)
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(msg).Elem().Set(reflect.ValueOf(v).Elem())
+	return nil
+}
+
+// EncodeAny prefixes msg.Encode() with its registered ID as a varint.
+func (r *Registry) EncodeAny(msg Encoder) ([]byte, error) {
+	id, ok := r.ID(msg)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnregistered, msg)
+	}
+	payload := msg.Encode()
+	out := make([]byte, 0, binary.MaxVarintLen32+len(payload))
+	out = binary.AppendUvarint(out, uint64(id))
+	return append(out, payload...), nil
+}
+
+// DecodeAny reads the ID written by EncodeAny and dispatches to the matching
+// Decode<T>. The concrete type can be recovered with a type switch.
+func (r *Registry) DecodeAny(data []byte) (Encoder, error) {
+	id, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, ErrShortFrame
+	}
+	if id > 0xFFFFFFFF {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownType, id)
+	}
+	return r.Decode(uint32(id), data[n:])
+}
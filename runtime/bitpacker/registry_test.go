@@ -0,0 +1,90 @@
+package bitpacker_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	gen "bit-parser/generated/go_bench/go"
+	"bit-parser/runtime/bitpacker"
+)
+
+func TestRegisterDuplicate(t *testing.T) {
+	reg := bitpacker.NewRegistry()
+	if err := bitpacker.Register(reg, 1, gen.DecodeVec3); err != nil {
+		t.Fatal(err)
+	}
+	if err := bitpacker.Register(reg, 1, gen.DecodeItem); !errors.Is(err, bitpacker.ErrDuplicateID) {
+		t.Errorf("same ID: %v, want ErrDuplicateID", err)
+	}
+	if err := bitpacker.Register(reg, 2, gen.DecodeVec3); !errors.Is(err, bitpacker.ErrDuplicateID) {
+		t.Errorf("same type: %v, want ErrDuplicateID", err)
+	}
+	if _, ok := reg.ID(&gen.Item{}); ok {
+		t.Error("failed Register left Item registered")
+	}
+}
+
+func TestRegisterStable(t *testing.T) {
+	reg := bitpacker.NewRegistry()
+	id, err := bitpacker.RegisterStable(reg, gen.DecodeWorldState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != bitpacker.StableID("WorldState") {
+		t.Errorf("ID %d, want StableID(\"WorldState\") = %d", id, bitpacker.StableID("WorldState"))
+	}
+	if got, ok := reg.ID(&gen.WorldState{}); !ok || got != id {
+		t.Errorf("reg.ID = %d, %v", got, ok)
+	}
+}
+
+func TestEncodeAnyRoundTrip(t *testing.T) {
+	reg := bitpacker.NewRegistry()
+	bitpacker.Register(reg, 1, gen.DecodeVec3)
+	bitpacker.Register(reg, 300, gen.DecodeItem) // two-byte varint
+
+	for _, msg := range []bitpacker.Encoder{
+		&gen.Vec3{X: 1, Y: -2, Z: 3},
+		&gen.Item{Id: 7, Name: "sword", Value: 100, Weight: 3, Rarity: "rare"},
+	} {
+		data, err := reg.EncodeAny(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := reg.DecodeAny(data)
+		if err != nil {
+			t.Fatalf("DecodeAny(%T): %v", msg, err)
+		}
+		switch want := msg.(type) {
+		case *gen.Vec3:
+			if v, ok := got.(*gen.Vec3); !ok || *v != *want {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		case *gen.Item:
+			if v, ok := got.(*gen.Item); !ok || *v != *want {
+				t.Errorf("got %#v, want %#v", got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeAnyUnknown(t *testing.T) {
+	reg := bitpacker.NewRegistry()
+	bitpacker.Register(reg, 1, gen.DecodeVec3)
+	payload := (&gen.Vec3{X: 1}).Encode()
+
+	if _, err := reg.EncodeAny(&gen.Item{}); !errors.Is(err, bitpacker.ErrUnregistered) {
+		t.Errorf("EncodeAny of an unregistered type: %v, want ErrUnregistered", err)
+	}
+	if _, err := reg.DecodeAny(nil); !errors.Is(err, bitpacker.ErrShortFrame) {
+		t.Errorf("DecodeAny(nil): %v, want ErrShortFrame", err)
+	}
+	if _, err := reg.DecodeAny(append(binary.AppendUvarint(nil, 2), payload...)); !errors.Is(err, bitpacker.ErrUnknownType) {
+		t.Errorf("unknown ID: %v, want ErrUnknownType", err)
+	}
+	// 1<<32 | 1 must not be truncated to the registered ID 1.
+	if _, err := reg.DecodeAny(append(binary.AppendUvarint(nil, 1<<32|1), payload...)); !errors.Is(err, bitpacker.ErrUnknownType) {
+		t.Errorf("ID over 32 bits: %v, want ErrUnknownType", err)
+	}
+}
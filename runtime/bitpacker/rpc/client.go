@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"bit-parser/runtime/bitpacker"
+)
+
+// Client issues calls over one connection. It is safe for concurrent use;
+// every call gets its own request ID.
+type Client struct {
+	peer   *peer
+	window int
+
+	mu      sync.Mutex
+	nextID  uint64
+	calls   map[uint64]chan *frame
+	streams map[uint64]*Stream
+	err     error
+	done    chan struct{}
+}
+
+// NewClient starts a Client on conn, which it owns from then on.
+func NewClient(conn net.Conn, cfg Config) *Client {
+	c := &Client{
+		peer:    newPeer(conn, cfg.MaxFrameSize),
+		window:  cfg.StreamWindow,
+		calls:   make(map[uint64]chan *frame),
+		streams: make(map[uint64]*Stream),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Dial connects to addr ("tcp", "unix", ...) and starts a Client on it.
+func Dial(ctx context.Context, network, addr string, cfg Config) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, cfg), nil
+}
+
+// Call sends req to method and decodes the reply with the generated
+// Decode<T>:
+//
+//	reply, err := rpc.Call(ctx, client, "Matchmaking.Join", req, gen.DecodeJoinReply)
+//
+// A failure reported by the server is an *Error.
+func Call[T any, P interface {
+	*T
+	bitpacker.Encoder
+}](ctx context.Context, c *Client, method string, req bitpacker.Encoder, decode func([]byte) (P, error)) (P, error) {
+	data, err := c.Invoke(ctx, method, req.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// Invoke sends an encoded request and returns the encoded reply. When ctx
+// ends first the server is told to cancel the call and ctx.Err() is
+// returned.
+func (c *Client) Invoke(ctx context.Context, method string, req []byte) ([]byte, error) {
+	timeout, err := callTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, ch, err := c.newCall()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.peer.send(&frame{kind: kindRequest, id: id, method: method, timeout: timeout, payload: req}); err != nil {
+		c.endCall(id)
+		if errors.Is(err, ErrFrameTooLarge) {
+			return nil, err
+		}
+		c.closeWithErr(err)
+		return nil, c.closedErr()
+	}
+	select {
+	case f := <-ch:
+		if f.kind == kindError {
+			return nil, decodeError(f.payload)
+		}
+		return f.payload, nil
+	case <-ctx.Done():
+		if c.endCall(id) {
+			c.peer.send(&frame{kind: kindCancel, id: id})
+		}
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.closedErr()
+	}
+}
+
+// callTimeout is what is left of ctx's deadline, or 0 without one.
+func callTimeout(ctx context.Context) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, nil
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return 0, context.DeadlineExceeded
+	}
+	return timeout, nil
+}
+
+func (c *Client) newCall() (uint64, chan *frame, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	c.nextID++
+	ch := make(chan *frame, 1)
+	c.calls[c.nextID] = ch
+	return c.nextID, ch, nil
+}
+
+// endCall forgets call id and reports whether it was still waiting.
+func (c *Client) endCall(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.calls[id]
+	delete(c.calls, id)
+	return ok
+}
+
+func (c *Client) readLoop() {
+	for {
+		f, err := c.peer.recv()
+		if err != nil {
+			c.closeWithErr(err)
+			return
+		}
+		switch f.kind {
+		case kindStreamMsg, kindStreamEnd, kindCredit:
+			c.mu.Lock()
+			st := c.streams[f.id]
+			c.mu.Unlock()
+			if st != nil && st.handle(f) {
+				// The server has returned from its handler.
+				c.endStream(f.id)
+				st.stopSending(io.EOF)
+				st.cancel(io.EOF)
+			}
+			continue
+		case kindResponse, kindError:
+		default:
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.calls[f.id]
+		delete(c.calls, f.id)
+		c.mu.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+}
+
+// Close closes the connection. Calls still waiting return ErrClosed.
+func (c *Client) Close() error {
+	c.closeWithErr(ErrClosed)
+	return nil
+}
+
+func (c *Client) closeWithErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if err != ErrClosed {
+		err = fmt.Errorf("%w: %v", ErrClosed, err)
+	}
+	c.err = err
+	c.peer.conn.Close()
+	close(c.done)
+	for id, st := range c.streams {
+		delete(c.streams, id)
+		st.cancel(err)
+	}
+}
+
+func (c *Client) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func decodeError(payload []byte) *Error {
+	if len(payload) == 0 {
+		return &Error{Code: CodeHandler}
+	}
+	return &Error{Code: Code(payload[0]), Message: string(payload[1:])}
+}
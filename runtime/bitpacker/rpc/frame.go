@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Frames on the connection are a varint length followed by:
+//
+//	uint8  kind
+//	varint call id          chosen by the client, unique per connection
+//	kindRequest:  varint method length, method, varint timeout (µs, 0 = none), payload
+//	kindResponse: payload (the reply's Encode())
+//	kindError:    error message
+//	kindCancel:   nothing (also resets a stream)
+//	kindStreamOpen: as kindRequest, without a payload
+//	kindStreamMsg:  payload
+//	kindStreamEnd:  nothing, or an error as in kindError
+//	kindCredit:     varint number of further messages the peer may send
+const (
+	kindRequest byte = iota + 1
+	kindResponse
+	kindError
+	kindCancel
+	kindStreamOpen
+	kindStreamMsg
+	kindStreamEnd
+	kindCredit
+)
+
+// DefaultMaxFrameSize bounds a single frame unless overridden.
+const DefaultMaxFrameSize = 16 << 20
+
+var errMalformed = errors.New("rpc: malformed frame")
+
+type frame struct {
+	kind    byte
+	id      uint64
+	method  string
+	timeout time.Duration
+	n       uint64
+	payload []byte
+}
+
+// peer is one end of a connection; writes from concurrent calls are
+// serialized.
+type peer struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	maxSize int
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+	buf []byte
+}
+
+func newPeer(conn net.Conn, maxSize int) *peer {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &peer{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), maxSize: maxSize}
+}
+
+func (p *peer) send(f *frame) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	b := append(p.buf[:0], f.kind)
+	b = binary.AppendUvarint(b, f.id)
+	switch f.kind {
+	case kindRequest, kindStreamOpen:
+		b = binary.AppendUvarint(b, uint64(len(f.method)))
+		b = append(b, f.method...)
+		b = binary.AppendUvarint(b, uint64(f.timeout/time.Microsecond))
+	case kindCredit:
+		b = binary.AppendUvarint(b, f.n)
+	}
+	b = append(b, f.payload...)
+	p.buf = b
+	if len(b) > p.maxSize {
+		return ErrFrameTooLarge
+	}
+	var hdr [binary.MaxVarintLen64]byte
+	if _, err := p.w.Write(hdr[:binary.PutUvarint(hdr[:], uint64(len(b)))]); err != nil {
+		return err
+	}
+	if _, err := p.w.Write(b); err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+// recv reads the next frame. It is only called from the peer's read loop.
+func (p *peer) recv() (*frame, error) {
+	size, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 || size > uint64(p.maxSize) {
+		return nil, ErrFrameTooLarge
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		return nil, err
+	}
+	f := &frame{kind: b[0]}
+	b = b[1:]
+	var n int
+	if f.id, n = binary.Uvarint(b); n <= 0 {
+		return nil, errMalformed
+	}
+	b = b[n:]
+	switch f.kind {
+	case kindRequest, kindStreamOpen:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return nil, errMalformed
+		}
+		f.method = string(b[n : n+int(l)])
+		b = b[n+int(l):]
+		us, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errMalformed
+		}
+		f.timeout = time.Duration(us) * time.Microsecond
+		b = b[n:]
+	case kindCredit:
+		if f.n, n = binary.Uvarint(b); n <= 0 {
+			return nil, errMalformed
+		}
+		b = b[n:]
+	}
+	f.payload = b
+	return f, nil
+}
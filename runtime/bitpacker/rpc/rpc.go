@@ -0,0 +1,64 @@
+// Package rpc carries request/response calls between generated BitPacker
+// types over a stream connection (TCP, a unix socket, net.Pipe). Every
+// call has an ID chosen by the client, so many calls can be in flight on one
+// connection and replies come back in whatever order the handlers finish.
+// The caller's deadline travels with the request and bounds the handler's
+// context; cancelling the caller's context cancels the handler's too.
+// Streaming calls run over the same connection with per-stream flow control
+// (see Stream).
+//
+// Methods are plain strings. Service stubs are thin wrappers around Handle
+// and Call:
+//
+//	rpc.Handle(srv, "Matchmaking.Join", gen.DecodeJoinRequest, mm.Join)
+//
+//	reply, err := rpc.Call(ctx, client, "Matchmaking.Join", req, gen.DecodeJoinReply)
+package rpc
+
+import (
+	"errors"
+)
+
+var (
+	ErrClosed          = errors.New("rpc: connection closed")
+	ErrDuplicateMethod = errors.New("rpc: method already registered")
+	// ErrFrameTooLarge is returned for a request or message that does not
+	// fit in Config.MaxFrameSize. Nothing is sent and the connection stays
+	// usable.
+	ErrFrameTooLarge = errors.New("rpc: frame too large")
+)
+
+// Code classifies an Error.
+type Code uint8
+
+const (
+	// CodeHandler means the handler returned an error.
+	CodeHandler Code = iota
+	// CodeUnknownMethod means the server has no handler for the method.
+	CodeUnknownMethod
+	// CodeBadRequest means the request did not decode.
+	CodeBadRequest
+)
+
+// Error is what the server reported for a failed call. Only the text of a
+// handler's error crosses the connection.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "rpc: " + e.Message
+}
+
+type Config struct {
+	// MaxFrameSize bounds one frame, and with it one encoded message.
+	// Default 16 MiB.
+	MaxFrameSize int
+	// StreamWindow is how many messages the peer may send on a stream
+	// before this side has read them. Default 16.
+	StreamWindow int
+}
+
+// DefaultStreamWindow is used when Config.StreamWindow is not set.
+const DefaultStreamWindow = 16
@@ -0,0 +1,223 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gen "bit-parser/generated/go_bench/go"
+)
+
+// startServer serves srv on loopback and dials it with the same Config.
+func startServer(t *testing.T, srv *Server) *Client {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	c, err := Dial(context.Background(), "tcp", l.Addr().String(), srv.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		srv.Close()
+	})
+	return c
+}
+
+func TestCall(t *testing.T) {
+	srv := NewServer(Config{})
+	err := Handle(srv, "Geo.Mirror", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		return &gen.Vec3{X: p.Y, Y: p.X}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := startServer(t, srv)
+
+	got, err := Call(context.Background(), c, "Geo.Mirror", &gen.Vec3{X: 3, Y: -7}, gen.DecodeVec3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != (gen.Vec3{X: -7, Y: 3}) {
+		t.Fatalf("got %+v", *got)
+	}
+}
+
+func TestConcurrentCallsReplyOutOfOrder(t *testing.T) {
+	srv := NewServer(Config{})
+	// The first call blocks until the second has been answered.
+	release := make(chan struct{})
+	Handle(srv, "Geo.Echo", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		if p.X == 0 {
+			<-release
+		}
+		return p, nil
+	})
+	c := startServer(t, srv)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		got, err := Call(context.Background(), c, "Geo.Echo", &gen.Vec3{X: 0, Y: 1}, gen.DecodeVec3)
+		if err != nil || got.Y != 1 {
+			t.Errorf("slow call: %+v, %v", got, err)
+		}
+	}()
+	for i := int32(1); i <= 20; i++ {
+		got, err := Call(context.Background(), c, "Geo.Echo", &gen.Vec3{X: i, Y: i * 2}, gen.DecodeVec3)
+		if err != nil || got.X != i || got.Y != i*2 {
+			t.Fatalf("call %d: %+v, %v", i, got, err)
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestDeadlineReachesHandler(t *testing.T) {
+	srv := NewServer(Config{})
+	saw := make(chan error, 1)
+	Handle(srv, "Geo.Wait", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			saw <- errors.New("handler context has no deadline")
+			return p, nil
+		}
+		<-ctx.Done()
+		saw <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	c := startServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Call(ctx, c, "Geo.Wait", &gen.Vec3{}, gen.DecodeVec3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	select {
+	case err := <-saw:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("handler saw %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler deadline never fired")
+	}
+}
+
+func TestCancelReachesHandler(t *testing.T) {
+	srv := NewServer(Config{})
+	started := make(chan struct{})
+	saw := make(chan error, 1)
+	Handle(srv, "Geo.Wait", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		close(started)
+		<-ctx.Done()
+		saw <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	c := startServer(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := Call(ctx, c, "Geo.Wait", &gen.Vec3{}, gen.DecodeVec3); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want Canceled", err)
+	}
+	select {
+	case err := <-saw:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("handler saw %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler was not cancelled")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := NewServer(Config{})
+	Handle(srv, "Geo.Fail", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		return nil, errors.New("out of range")
+	})
+	if err := Handle(srv, "Geo.Fail", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		return p, nil
+	}); !errors.Is(err, ErrDuplicateMethod) {
+		t.Fatalf("duplicate Handle: %v", err)
+	}
+	c := startServer(t, srv)
+
+	cases := []struct {
+		method string
+		req    []byte
+		code   Code
+	}{
+		{"Geo.Fail", (&gen.Vec3{}).Encode(), CodeHandler},
+		{"Geo.Missing", (&gen.Vec3{}).Encode(), CodeUnknownMethod},
+		{"Geo.Fail", []byte{0}, CodeBadRequest},
+	}
+	for _, tc := range cases {
+		_, err := c.Invoke(context.Background(), tc.method, tc.req)
+		var e *Error
+		if !errors.As(err, &e) || e.Code != tc.code {
+			t.Errorf("%s: got %v, want code %d", tc.method, err, tc.code)
+		}
+	}
+}
+
+func TestClosedConnectionFailsPendingCalls(t *testing.T) {
+	srv := NewServer(Config{})
+	started := make(chan struct{})
+	Handle(srv, "Geo.Hang", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	c := startServer(t, srv)
+
+	go func() {
+		<-started
+		srv.Close()
+	}()
+	if _, err := Call(context.Background(), c, "Geo.Hang", &gen.Vec3{}, gen.DecodeVec3); !errors.Is(err, ErrClosed) {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+	if _, err := Call(context.Background(), c, "Geo.Hang", &gen.Vec3{}, gen.DecodeVec3); !errors.Is(err, ErrClosed) {
+		t.Fatalf("after close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	srv := NewServer(Config{MaxFrameSize: 256})
+	Handle(srv, "Geo.Echo", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.Vec3, error) {
+		return p, nil
+	})
+	Handle(srv, "Geo.World", gen.DecodeVec3, func(ctx context.Context, p *gen.Vec3) (*gen.WorldState, error) {
+		return &gen.WorldState{Seed: strings.Repeat("x", 1000)}, nil
+	})
+	c := startServer(t, srv)
+
+	// An oversized request fails on its own; the connection stays usable.
+	if _, err := c.Invoke(context.Background(), "Geo.Echo", make([]byte, 1000)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("oversized request: %v, want ErrFrameTooLarge", err)
+	}
+	if got, err := Call(context.Background(), c, "Geo.Echo", &gen.Vec3{X: 1}, gen.DecodeVec3); err != nil || got.X != 1 {
+		t.Fatalf("call after an oversized request: %+v, %v", got, err)
+	}
+
+	// An oversized reply comes back as an error instead of hanging.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var e *Error
+	if _, err := Call(ctx, c, "Geo.World", &gen.Vec3{}, gen.DecodeWorldState); !errors.As(err, &e) || e.Code != CodeHandler {
+		t.Fatalf("oversized reply: %v, want a CodeHandler *Error", err)
+	}
+	if got, err := Call(context.Background(), c, "Geo.Echo", &gen.Vec3{X: 2}, gen.DecodeVec3); err != nil || got.X != 2 {
+		t.Fatalf("call after an oversized reply: %+v, %v", got, err)
+	}
+}
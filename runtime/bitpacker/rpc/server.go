@@ -0,0 +1,245 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"bit-parser/runtime/bitpacker"
+)
+
+type handlerFunc func(ctx context.Context, req []byte) ([]byte, error)
+
+// Server dispatches calls to the handlers registered with Handle. It is
+// safe for concurrent use.
+type Server struct {
+	cfg Config
+
+	mu        sync.Mutex
+	methods   map[string]handlerFunc
+	streams   map[string]func(context.Context, *Stream) error
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+func NewServer(cfg Config) *Server {
+	return &Server{
+		cfg:       cfg,
+		methods:   make(map[string]handlerFunc),
+		streams:   make(map[string]func(context.Context, *Stream) error),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Handle registers h for method. decode is the generated Decode<T> of the
+// request type:
+//
+//	rpc.Handle(srv, "Matchmaking.Join", gen.DecodeJoinRequest, mm.Join)
+//
+// Each call runs in its own goroutine.
+func Handle[T any, P interface {
+	*T
+	bitpacker.Encoder
+}, R bitpacker.Encoder](s *Server, method string, decode func([]byte) (P, error), h func(context.Context, P) (R, error)) error {
+	return s.register(method, func(ctx context.Context, data []byte) ([]byte, error) {
+		req, err := decode(data)
+		if err != nil {
+			return nil, &Error{Code: CodeBadRequest, Message: err.Error()}
+		}
+		resp, err := h(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Encode(), nil
+	})
+}
+
+func (s *Server) register(method string, h handlerFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.methods[method]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMethod, method)
+	}
+	if _, ok := s.streams[method]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMethod, method)
+	}
+	s.methods[method] = h
+	return nil
+}
+
+func (s *Server) lookup(method string) handlerFunc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.methods[method]
+}
+
+func (s *Server) lookupStream(method string) func(context.Context, *Stream) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[method]
+}
+
+// Serve accepts connections on l until l fails or the Server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves calls on conn until it fails or the Server is closed,
+// then closes it. In-flight handlers see their context cancelled.
+func (s *Server) ServeConn(conn net.Conn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	sc := &serverConn{
+		srv:     s,
+		peer:    newPeer(conn, s.cfg.MaxFrameSize),
+		calls:   make(map[uint64]context.CancelFunc),
+		streams: make(map[uint64]*Stream),
+	}
+	err := sc.serve()
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	if errors.Is(err, net.ErrClosed) {
+		err = ErrClosed
+	}
+	return err
+}
+
+// Close stops every Serve loop and closes every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+type serverConn struct {
+	srv  *Server
+	peer *peer
+
+	mu      sync.Mutex
+	calls   map[uint64]context.CancelFunc
+	streams map[uint64]*Stream
+}
+
+func (sc *serverConn) serve() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		sc.peer.conn.Close()
+	}()
+	for {
+		f, err := sc.peer.recv()
+		if err != nil {
+			return err
+		}
+		switch f.kind {
+		case kindRequest:
+			sc.start(ctx, f)
+		case kindStreamOpen:
+			sc.startStream(ctx, f)
+		case kindStreamMsg, kindStreamEnd, kindCredit:
+			if st := sc.stream(f.id); st != nil {
+				st.handle(f)
+			}
+		case kindCancel:
+			sc.mu.Lock()
+			if cancel, ok := sc.calls[f.id]; ok {
+				cancel()
+			}
+			sc.mu.Unlock()
+		}
+	}
+}
+
+func (sc *serverConn) start(parent context.Context, f *frame) {
+	h := sc.srv.lookup(f.method)
+	if h == nil {
+		sc.fail(f.id, &Error{Code: CodeUnknownMethod, Message: "unknown method " + f.method})
+		return
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if f.timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, f.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	sc.mu.Lock()
+	sc.calls[f.id] = cancel
+	sc.mu.Unlock()
+	go func() {
+		defer func() {
+			sc.mu.Lock()
+			delete(sc.calls, f.id)
+			sc.mu.Unlock()
+			cancel()
+		}()
+		resp, err := h(ctx, f.payload)
+		if err != nil {
+			sc.fail(f.id, err)
+			return
+		}
+		err = sc.peer.send(&frame{kind: kindResponse, id: f.id, payload: resp})
+		if errors.Is(err, ErrFrameTooLarge) {
+			sc.fail(f.id, &Error{Code: CodeHandler, Message: "response too large"})
+		}
+	}()
+}
+
+func (sc *serverConn) fail(id uint64, err error) {
+	sc.peer.send(&frame{kind: kindError, id: id, payload: errorPayload(err)})
+}
+
+func errorPayload(err error) []byte {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Code: CodeHandler, Message: err.Error()}
+	}
+	return append([]byte{byte(e.Code)}, e.Message...)
+}
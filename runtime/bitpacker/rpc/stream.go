@@ -0,0 +1,392 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sync"
+
+	"bit-parser/runtime/bitpacker"
+)
+
+var (
+	errSendClosed  = errors.New("rpc: Send after CloseSend")
+	errFlowControl = errors.New("rpc: peer sent past its window")
+)
+
+// Stream is one streaming call, seen from either end. Messages flow both
+// ways until each side is done: the client ends its half with CloseSend, the
+// server by returning from its handler.
+//
+// Flow control is per message. Each side may have at most the other side's
+// Config.StreamWindow messages in flight; Send blocks until the receiver has
+// consumed enough of them with Recv. A slow reader therefore holds up the
+// sender instead of growing a buffer.
+//
+// Send and Recv may run concurrently, but neither may be called from two
+// goroutines at once.
+type Stream struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	peer   *peer
+	id     uint64
+	window int
+
+	// in is written and closed only by the connection's read loop; endErr
+	// is set before the close.
+	in     chan []byte
+	ended  bool
+	endErr error
+
+	mu       sync.Mutex
+	credit   uint64
+	consumed int
+	sendErr  error
+	wake     chan struct{}
+}
+
+func newStream(ctx context.Context, cancel context.CancelCauseFunc, p *peer, id uint64, window int) *Stream {
+	if window <= 0 {
+		window = DefaultStreamWindow
+	}
+	return &Stream{
+		ctx:    ctx,
+		cancel: cancel,
+		peer:   p,
+		id:     id,
+		window: window,
+		in:     make(chan []byte, window),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Context is cancelled when the call is cancelled, times out or loses its
+// connection.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Send writes msg, waiting for the peer to grant window space. It returns
+// io.EOF once the server has finished the call; the server's result is then
+// available from Recv.
+func (s *Stream) Send(msg bitpacker.Encoder) error {
+	data := msg.Encode()
+	for {
+		s.mu.Lock()
+		if s.sendErr != nil {
+			err := s.sendErr
+			s.mu.Unlock()
+			return err
+		}
+		if s.credit > 0 {
+			s.credit--
+			s.mu.Unlock()
+			err := s.peer.send(&frame{kind: kindStreamMsg, id: s.id, payload: data})
+			if errors.Is(err, ErrFrameTooLarge) {
+				// Nothing went out, so the window slot is still free.
+				s.mu.Lock()
+				s.credit++
+				s.mu.Unlock()
+			}
+			return err
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return context.Cause(s.ctx)
+		}
+	}
+}
+
+// CloseSend tells the peer no more messages follow. Recv keeps working.
+func (s *Stream) CloseSend() error {
+	s.mu.Lock()
+	if s.sendErr != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.sendErr = errSendClosed
+	s.mu.Unlock()
+	return s.peer.send(&frame{kind: kindStreamEnd, id: s.id})
+}
+
+// Recv returns the next encoded message. Once the peer has finished sending
+// and everything before that has been read, it returns io.EOF, or on the
+// client the *Error the server's handler returned.
+func (s *Stream) Recv() ([]byte, error) {
+	var data []byte
+	var ok bool
+	select {
+	case data, ok = <-s.in:
+	default:
+		select {
+		case data, ok = <-s.in:
+		case <-s.ctx.Done():
+			return nil, context.Cause(s.ctx)
+		}
+	}
+	if !ok {
+		return nil, s.endErr
+	}
+	s.mu.Lock()
+	s.consumed++
+	grant := 0
+	if s.consumed >= max(1, s.window/2) {
+		grant, s.consumed = s.consumed, 0
+	}
+	s.mu.Unlock()
+	if grant > 0 {
+		s.peer.send(&frame{kind: kindCredit, id: s.id, n: uint64(grant)})
+	}
+	return data, nil
+}
+
+// Receive reads the next message from s with the generated Decode<T>.
+func Receive[T any, P interface {
+	*T
+	bitpacker.Encoder
+}](s *Stream, decode func([]byte) (P, error)) (P, error) {
+	data, err := s.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+// Messages iterates over the messages of s until the peer finishes. A
+// failed call or a message that does not decode is yielded as the last
+// error:
+//
+//	for snap, err := range rpc.Messages(stream, gen.DecodeSnapshot) {
+func Messages[T any, P interface {
+	*T
+	bitpacker.Encoder
+}](s *Stream, decode func([]byte) (P, error)) iter.Seq2[P, error] {
+	return func(yield func(P, error) bool) {
+		for {
+			data, err := s.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			msg, err := decode(data)
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// grantWindow gives the peer its initial credit.
+func (s *Stream) grantWindow() error {
+	return s.peer.send(&frame{kind: kindCredit, id: s.id, n: uint64(s.window)})
+}
+
+// handle applies a frame for this stream. It runs on the connection's read
+// loop and reports whether the frame ended the peer's half.
+func (s *Stream) handle(f *frame) bool {
+	switch f.kind {
+	case kindStreamMsg:
+		if s.ended {
+			return false
+		}
+		select {
+		case s.in <- f.payload:
+		default:
+			s.cancel(errFlowControl)
+		}
+	case kindCredit:
+		s.mu.Lock()
+		s.credit += f.n
+		s.mu.Unlock()
+		s.signal()
+	case kindStreamEnd:
+		if s.ended {
+			return false
+		}
+		s.ended = true
+		s.endErr = io.EOF
+		if len(f.payload) > 0 {
+			s.endErr = decodeError(f.payload)
+		}
+		close(s.in)
+		return true
+	}
+	return false
+}
+
+// stopSending makes further Sends return err.
+func (s *Stream) stopSending(err error) {
+	s.mu.Lock()
+	if s.sendErr == nil || s.sendErr == errSendClosed {
+		s.sendErr = err
+	}
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *Stream) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// OpenStream starts a streaming call to method. The stream lives until the
+// server finishes it or ctx ends; ctx's deadline is sent to the server like
+// a unary call's.
+//
+// A server-streaming call sends its request and closes its half:
+//
+//	st, err := client.OpenStream(ctx, "Sync.Subscribe")
+//	st.Send(filter)
+//	st.CloseSend()
+//	for snap, err := range rpc.Messages(st, gen.DecodeSnapshot) {
+func (c *Client) OpenStream(ctx context.Context, method string) (*Stream, error) {
+	timeout, err := callTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sctx, cancel := context.WithCancelCause(ctx)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		cancel(err)
+		return nil, err
+	}
+	c.nextID++
+	st := newStream(sctx, cancel, c.peer, c.nextID, c.window)
+	c.streams[st.id] = st
+	c.mu.Unlock()
+
+	err = c.peer.send(&frame{kind: kindStreamOpen, id: st.id, method: method, timeout: timeout})
+	if err == nil {
+		err = st.grantWindow()
+	}
+	if errors.Is(err, ErrFrameTooLarge) {
+		c.endStream(st.id)
+		cancel(err)
+		return nil, err
+	}
+	if err != nil {
+		c.closeWithErr(err)
+		return nil, c.closedErr()
+	}
+	go func() {
+		<-sctx.Done()
+		if c.endStream(st.id) {
+			c.peer.send(&frame{kind: kindCancel, id: st.id})
+		}
+	}()
+	return st, nil
+}
+
+// endStream forgets stream id and reports whether it was still open.
+func (c *Client) endStream(id uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.streams[id]
+	delete(c.streams, id)
+	return ok
+}
+
+// HandleStream registers h for a streaming method. h reads with Recv,
+// Receive or Messages and writes with Send; the call ends when h returns,
+// and a non-nil error reaches the client's Recv as an *Error.
+func (s *Server) HandleStream(method string, h func(ctx context.Context, st *Stream) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.methods[method]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMethod, method)
+	}
+	if _, ok := s.streams[method]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMethod, method)
+	}
+	s.streams[method] = h
+	return nil
+}
+
+// HandleServerStream registers a method that takes one request and streams
+// replies:
+//
+//	rpc.HandleServerStream(srv, "Sync.Subscribe", gen.DecodeFilter,
+//		func(ctx context.Context, f *gen.Filter, st *rpc.Stream) error { ... })
+func HandleServerStream[T any, P interface {
+	*T
+	bitpacker.Encoder
+}](s *Server, method string, decode func([]byte) (P, error), h func(context.Context, P, *Stream) error) error {
+	return s.HandleStream(method, func(ctx context.Context, st *Stream) error {
+		data, err := st.Recv()
+		if err == io.EOF {
+			return &Error{Code: CodeBadRequest, Message: "missing request"}
+		}
+		if err != nil {
+			return err
+		}
+		req, err := decode(data)
+		if err != nil {
+			return &Error{Code: CodeBadRequest, Message: err.Error()}
+		}
+		return h(ctx, req, st)
+	})
+}
+
+func (sc *serverConn) startStream(parent context.Context, f *frame) {
+	h := sc.srv.lookupStream(f.method)
+	if h == nil {
+		sc.end(f.id, &Error{Code: CodeUnknownMethod, Message: "unknown method " + f.method})
+		return
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	if f.timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, f.timeout)
+		cancelCause := cancel
+		cancel = func(err error) {
+			cancelCause(err)
+			stop()
+		}
+	}
+	st := newStream(ctx, cancel, sc.peer, f.id, sc.srv.cfg.StreamWindow)
+	sc.mu.Lock()
+	sc.calls[f.id] = func() { cancel(context.Canceled) }
+	sc.streams[f.id] = st
+	sc.mu.Unlock()
+	go func() {
+		defer func() {
+			sc.mu.Lock()
+			delete(sc.calls, f.id)
+			delete(sc.streams, f.id)
+			sc.mu.Unlock()
+			cancel(context.Canceled)
+		}()
+		err := st.grantWindow()
+		if err == nil {
+			err = h(ctx, st)
+		}
+		st.stopSending(io.EOF)
+		sc.end(f.id, err)
+	}()
+}
+
+func (sc *serverConn) stream(id uint64) *Stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+// end finishes stream id, with err reported to the client if non-nil.
+func (sc *serverConn) end(id uint64, err error) {
+	var payload []byte
+	if err != nil {
+		payload = errorPayload(err)
+	}
+	sc.peer.send(&frame{kind: kindStreamEnd, id: id, payload: payload})
+}
@@ -0,0 +1,237 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gen "bit-parser/generated/go_bench/go"
+)
+
+func TestServerStream(t *testing.T) {
+	srv := NewServer(Config{})
+	err := HandleServerStream(srv, "Sync.Subscribe", gen.DecodeVec3, func(ctx context.Context, from *gen.Vec3, st *Stream) error {
+		for i := int32(0); i < 100; i++ {
+			if err := st.Send(&gen.Vec3{X: from.X + i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := startServer(t, srv)
+
+	st, err := c.OpenStream(context.Background(), "Sync.Subscribe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Send(&gen.Vec3{X: 1000})
+	st.CloseSend()
+	want := int32(1000)
+	for p, err := range Messages(st, gen.DecodeVec3) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.X != want {
+			t.Fatalf("got %d, want %d", p.X, want)
+		}
+		want++
+	}
+	if want != 1100 {
+		t.Fatalf("got %d messages, want 100", want-1000)
+	}
+	if err := st.Send(&gen.Vec3{}); err != io.EOF {
+		t.Fatalf("Send after the server finished: %v, want io.EOF", err)
+	}
+}
+
+func TestBidiStream(t *testing.T) {
+	srv := NewServer(Config{})
+	var received atomic.Int32
+	srv.HandleStream("Sync.Echo", func(ctx context.Context, st *Stream) error {
+		for p, err := range Messages(st, gen.DecodeVec3) {
+			if err != nil {
+				return err
+			}
+			received.Add(1)
+			if err := st.Send(&gen.Vec3{X: p.X, Y: -p.X}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	c := startServer(t, srv)
+
+	st, err := c.OpenStream(context.Background(), "Sync.Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int32(0); i < 50; i++ {
+		if err := st.Send(&gen.Vec3{X: i}); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Receive(st, gen.DecodeVec3)
+		if err != nil || p.X != i || p.Y != -i {
+			t.Fatalf("echo %d: %+v, %v", i, p, err)
+		}
+	}
+	st.CloseSend()
+	if _, err := st.Recv(); err != io.EOF {
+		t.Fatalf("after CloseSend: %v, want io.EOF", err)
+	}
+	if n := received.Load(); n != 50 {
+		t.Fatalf("server received %d, want 50", n)
+	}
+}
+
+func TestStreamBackpressure(t *testing.T) {
+	const window = 4
+	srv := NewServer(Config{StreamWindow: window})
+	var sent atomic.Int32
+	srv.HandleStream("Sync.Flood", func(ctx context.Context, st *Stream) error {
+		for i := int32(0); i < 64; i++ {
+			if err := st.Send(&gen.Vec3{X: i}); err != nil {
+				return err
+			}
+			sent.Add(1)
+		}
+		return nil
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+	c, err := Dial(context.Background(), "tcp", l.Addr().String(), Config{StreamWindow: window})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	st, err := c.OpenStream(context.Background(), "Sync.Flood")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is read yet, so the server may only get one window ahead.
+	time.Sleep(100 * time.Millisecond)
+	if n := sent.Load(); n != window {
+		t.Fatalf("server sent %d before the client read anything, want %d", n, window)
+	}
+	var got int32
+	for p, err := range Messages(st, gen.DecodeVec3) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.X != got {
+			t.Fatalf("got %d, want %d", p.X, got)
+		}
+		got++
+		if ahead := sent.Load() - got; ahead > window {
+			t.Fatalf("server is %d messages ahead of the reader, window is %d", ahead, window)
+		}
+	}
+	if got != 64 {
+		t.Fatalf("got %d messages, want 64", got)
+	}
+}
+
+func TestStreamHandlerError(t *testing.T) {
+	srv := NewServer(Config{})
+	srv.HandleStream("Sync.Fail", func(ctx context.Context, st *Stream) error {
+		st.Send(&gen.Vec3{X: 1})
+		return errors.New("world unloaded")
+	})
+	c := startServer(t, srv)
+
+	st, err := c.OpenStream(context.Background(), "Sync.Fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := Receive(st, gen.DecodeVec3); err != nil || p.X != 1 {
+		t.Fatalf("first message: %+v, %v", p, err)
+	}
+	_, err = st.Recv()
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeHandler || e.Message != "world unloaded" {
+		t.Fatalf("got %v, want the handler's error", err)
+	}
+
+	st, err = c.OpenStream(context.Background(), "Sync.Missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Recv(); !errors.As(err, &e) || e.Code != CodeUnknownMethod {
+		t.Fatalf("unknown method: %v", err)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	srv := NewServer(Config{})
+	saw := make(chan error, 1)
+	srv.HandleStream("Sync.Wait", func(ctx context.Context, st *Stream) error {
+		_, err := st.Recv()
+		saw <- err
+		return err
+	})
+	c := startServer(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st, err := c.OpenStream(ctx, "Sync.Wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := st.Recv(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("client Recv: %v, want Canceled", err)
+	}
+	select {
+	case err := <-saw:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("server Recv: %v, want Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server stream was not cancelled")
+	}
+}
+
+func TestStreamFrameTooLarge(t *testing.T) {
+	srv := NewServer(Config{MaxFrameSize: 256, StreamWindow: 1})
+	srv.HandleStream("Sync.Echo", func(ctx context.Context, st *Stream) error {
+		for p, err := range Messages(st, gen.DecodeVec3) {
+			if err != nil {
+				return err
+			}
+			if err := st.Send(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	c := startServer(t, srv)
+
+	if _, err := c.OpenStream(context.Background(), strings.Repeat("m", 1000)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("oversized method: %v, want ErrFrameTooLarge", err)
+	}
+	st, err := c.OpenStream(context.Background(), "Sync.Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The failed Send does not use up the only window slot.
+	if err := st.Send(&gen.WorldState{Seed: strings.Repeat("x", 1000)}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("oversized message: %v, want ErrFrameTooLarge", err)
+	}
+	if err := st.Send(&gen.Vec3{X: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := Receive(st, gen.DecodeVec3); err != nil || p.X != 5 {
+		t.Fatalf("echo after an oversized message: %+v, %v", p, err)
+	}
+}
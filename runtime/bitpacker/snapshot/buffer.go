@@ -0,0 +1,228 @@
+// Package snapshot buffers server snapshots on the client and interpolates
+// between them, so rendering can run a fixed delay behind the newest state
+// and ride out jitter and loss.
+//
+// Interpolation is done by a LerpFunc for the snapshot type. Until the
+// generator emits them, write one with Lerp for each numeric field:
+//
+//	func lerpVec3(a, b *gen.Vec3, t float32) *gen.Vec3 {
+//		return &gen.Vec3{
+//			X: snapshot.Lerp(a.X, b.X, t),
+//			Y: snapshot.Lerp(a.Y, b.Y, t),
+//			Z: snapshot.Lerp(a.Z, b.Z, t),
+//		}
+//	}
+package snapshot
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LerpFunc blends two snapshots: t=0 gives a, t=1 gives b. t above 1 is
+// used for extrapolation, so the function should not clamp.
+type LerpFunc[T any] func(a, b *T, t float32) *T
+
+type Number interface {
+	~int8 | ~int16 | ~int32 | ~int64 | ~int |
+		~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uint |
+		~float32 | ~float64
+}
+
+// Lerp interpolates a numeric field. Integer results are rounded and
+// clamped to the type's range, so extrapolating past zero or the maximum
+// holds there instead of wrapping.
+func Lerp[N Number](a, b N, t float32) N {
+	v := float64(a) + (float64(b)-float64(a))*float64(t)
+	if one := N(1); one/2 != 0 {
+		return N(v)
+	}
+	v = math.Round(v)
+	lo, hi := intRange[N]()
+	switch {
+	case v <= float64(lo):
+		return N(lo)
+	case v >= float64(hi):
+		return N(hi)
+	}
+	return N(v)
+}
+
+// intRange returns the bounds of the integer type N. It goes by Kind so
+// that named types (~int32 and so on) are covered too.
+func intRange[N Number]() (lo int64, hi uint64) {
+	switch reflect.TypeFor[N]().Kind() {
+	case reflect.Int8:
+		return math.MinInt8, math.MaxInt8
+	case reflect.Int16:
+		return math.MinInt16, math.MaxInt16
+	case reflect.Int32:
+		return math.MinInt32, math.MaxInt32
+	case reflect.Int64:
+		return math.MinInt64, math.MaxInt64
+	case reflect.Int:
+		return math.MinInt, math.MaxInt
+	case reflect.Uint8:
+		return 0, math.MaxUint8
+	case reflect.Uint16:
+		return 0, math.MaxUint16
+	case reflect.Uint32:
+		return 0, math.MaxUint32
+	case reflect.Uint64:
+		return 0, math.MaxUint64
+	case reflect.Uint:
+		return 0, math.MaxUint
+	}
+	panic("snapshot: not an integer type")
+}
+
+type Config struct {
+	// TickRate is the server simulation rate in ticks per second. It is
+	// required.
+	TickRate float64
+	// Delay is how far behind the newest snapshot Sample renders.
+	// Two or three snapshot intervals hide most jitter. Default 100ms.
+	Delay time.Duration
+	// MaxExtrapolation bounds how far past the newest snapshot Sample
+	// will predict when snapshots stop arriving; after that the state
+	// holds. Zero disables extrapolation.
+	MaxExtrapolation time.Duration
+	// Capacity is the number of snapshots kept. Default 32.
+	Capacity int
+}
+
+type entry[T any] struct {
+	tick  uint32
+	state *T
+}
+
+// Buffer holds snapshots ordered by server tick. It is safe for concurrent
+// use, typically Push from the network goroutine and Sample per frame.
+type Buffer[T any] struct {
+	cfg  Config
+	lerp LerpFunc[T]
+
+	mu      sync.Mutex
+	entries []entry[T]
+	// epoch is the smoothed local time at which tick 0 would have
+	// arrived; every snapshot contributes a sample.
+	epoch     time.Time
+	haveClock bool
+}
+
+// clockGain is the weight of one arrival in the epoch estimate, so a single
+// late or early snapshot moves the render clock by 1/16 of its jitter.
+const clockGain = 16
+
+// NewBuffer panics if cfg.TickRate is not positive.
+func NewBuffer[T any](lerp LerpFunc[T], cfg Config) *Buffer[T] {
+	if !(cfg.TickRate > 0) {
+		panic("snapshot: Config.TickRate must be positive")
+	}
+	if cfg.Delay <= 0 {
+		cfg.Delay = 100 * time.Millisecond
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = 32
+	}
+	return &Buffer[T]{cfg: cfg, lerp: lerp}
+}
+
+// Push stores the snapshot for tick, received at now. Late snapshots are
+// slotted into place; duplicates and ones older than the whole buffer are
+// dropped.
+func (b *Buffer[T]) Push(tick uint32, state *T, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := sort.Search(len(b.entries), func(i int) bool { return b.entries[i].tick >= tick })
+	if i < len(b.entries) && b.entries[i].tick == tick {
+		return
+	}
+	if i == 0 && len(b.entries) == b.cfg.Capacity {
+		return
+	}
+	b.entries = append(b.entries, entry[T]{})
+	copy(b.entries[i+1:], b.entries[i:])
+	b.entries[i] = entry[T]{tick: tick, state: state}
+	epoch := now.Add(-time.Duration(float64(tick) / b.cfg.TickRate * float64(time.Second)))
+	if !b.haveClock {
+		b.epoch, b.haveClock = epoch, true
+	} else {
+		b.epoch = b.epoch.Add(epoch.Sub(b.epoch) / clockGain)
+	}
+	if len(b.entries) > b.cfg.Capacity {
+		b.entries[0] = entry[T]{}
+		b.entries = b.entries[1:]
+	}
+}
+
+// Len returns the number of buffered snapshots.
+func (b *Buffer[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// RenderTick estimates the server tick to display at now: the tick that
+// arrived Delay ago, going by a running average of when ticks arrive. The
+// average keeps jitter in one snapshot from moving the render clock back.
+func (b *Buffer[T]) RenderTick(now time.Time) (float64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.renderTickLocked(now)
+}
+
+func (b *Buffer[T]) renderTickLocked(now time.Time) (float64, bool) {
+	if !b.haveClock || len(b.entries) == 0 {
+		return 0, false
+	}
+	return (now.Sub(b.epoch) - b.cfg.Delay).Seconds() * b.cfg.TickRate, true
+}
+
+// Sample returns the state to render at now. It reports false until a
+// snapshot has been pushed.
+func (b *Buffer[T]) Sample(now time.Time) (*T, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tick, ok := b.renderTickLocked(now)
+	if !ok {
+		return nil, false
+	}
+	return b.sampleLocked(tick), true
+}
+
+// SampleTick returns the state at a fractional server tick: interpolated
+// between the snapshots around it, clamped to the oldest, and extrapolated
+// up to MaxExtrapolation past the newest.
+func (b *Buffer[T]) SampleTick(tick float64) (*T, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) == 0 {
+		return nil, false
+	}
+	return b.sampleLocked(tick), true
+}
+
+func (b *Buffer[T]) sampleLocked(tick float64) *T {
+	n := len(b.entries)
+	if tick <= float64(b.entries[0].tick) {
+		return b.entries[0].state
+	}
+	newest := b.entries[n-1]
+	if tick >= float64(newest.tick) {
+		if n < 2 || b.cfg.MaxExtrapolation <= 0 {
+			return newest.state
+		}
+		prev := b.entries[n-2]
+		over := min(tick-float64(newest.tick), b.cfg.MaxExtrapolation.Seconds()*b.cfg.TickRate)
+		span := float64(newest.tick - prev.tick)
+		return b.lerp(prev.state, newest.state, float32(1+over/span))
+	}
+	i := sort.Search(n, func(i int) bool { return float64(b.entries[i].tick) > tick })
+	from, to := b.entries[i-1], b.entries[i]
+	t := (tick - float64(from.tick)) / float64(to.tick-from.tick)
+	return b.lerp(from.state, to.state, float32(t))
+}
@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+type health uint8
+
+func TestLerp(t *testing.T) {
+	if got := Lerp[int32](0, 10, 0.55); got != 6 {
+		t.Errorf("Lerp[int32](0, 10, 0.55) = %d, want 6", got)
+	}
+	if got := Lerp[float32](1, 2, 1.5); got != 2.5 {
+		t.Errorf("Lerp[float32](1, 2, 1.5) = %v, want 2.5", got)
+	}
+	// Extrapolation stops at the ends of integer ranges.
+	if got := Lerp[uint8](10, 5, 3); got != 0 {
+		t.Errorf("Lerp[uint8](10, 5, 3) = %d, want 0", got)
+	}
+	if got := Lerp[uint8](250, 255, 3); got != 255 {
+		t.Errorf("Lerp[uint8](250, 255, 3) = %d, want 255", got)
+	}
+	if got := Lerp[health](250, 255, 3); got != 255 {
+		t.Errorf("Lerp[health](250, 255, 3) = %d, want 255", got)
+	}
+	if got := Lerp[int8](100, 120, 3); got != 127 {
+		t.Errorf("Lerp[int8](100, 120, 3) = %d, want 127", got)
+	}
+	if got := Lerp[int8](-100, -120, 3); got != -128 {
+		t.Errorf("Lerp[int8](-100, -120, 3) = %d, want -128", got)
+	}
+	if got := Lerp[uint64](math.MaxUint64-1, math.MaxUint64, 4); got != math.MaxUint64 {
+		t.Errorf("Lerp[uint64] near the maximum = %d", got)
+	}
+	if got := Lerp[int64](math.MinInt64+1, math.MinInt64, 4); got != math.MinInt64 {
+		t.Errorf("Lerp[int64] near the minimum = %d", got)
+	}
+}
+
+func lerpFloat(a, b *float64, t float32) *float64 {
+	v := Lerp(*a, *b, t)
+	return &v
+}
+
+func TestSampleTick(t *testing.T) {
+	b := NewBuffer(lerpFloat, Config{TickRate: 10, MaxExtrapolation: 100 * time.Millisecond})
+	now := time.Now()
+	for _, tick := range []uint32{10, 12, 11} { // 11 arrives late
+		v := float64(tick * 100)
+		b.Push(tick, &v, now)
+	}
+	cases := []struct{ tick, want float64 }{
+		{5, 1000},     // clamped to the oldest
+		{10.5, 1050},  // interpolated
+		{11.25, 1125}, // interpolated across the late snapshot
+		{12.5, 1250},  // extrapolated
+		{20, 1300},    // extrapolation stops after MaxExtrapolation (1 tick)
+	}
+	for _, tc := range cases {
+		got, ok := b.SampleTick(tc.tick)
+		if !ok || math.Abs(*got-tc.want) > 1e-9 {
+			t.Errorf("SampleTick(%v) = %v, want %v", tc.tick, *got, tc.want)
+		}
+	}
+}
+
+func TestRenderTickUnderJitter(t *testing.T) {
+	const rate = 20 // 50ms per tick
+	b := NewBuffer(lerpFloat, Config{TickRate: rate, Delay: 100 * time.Millisecond})
+	rng := rand.New(rand.NewPCG(1, 2))
+	start := time.Unix(1000, 0)
+	v := 0.0
+
+	// Snapshots arrive 20ms after they are sent, plus up to 40ms jitter.
+	// Frames run every 10ms.
+	next := uint32(0)
+	arrival := start.Add(20 * time.Millisecond)
+	prev := math.Inf(-1)
+	for frame := time.Duration(0); frame < 5*time.Second; frame += 10 * time.Millisecond {
+		now := start.Add(frame)
+		for !arrival.After(now) {
+			b.Push(next, &v, arrival)
+			next++
+			arrival = start.Add(time.Duration(next)*50*time.Millisecond + 20*time.Millisecond +
+				time.Duration(rng.Int64N(int64(40*time.Millisecond))))
+		}
+		tick, ok := b.RenderTick(now)
+		if !ok {
+			continue
+		}
+		if tick < prev {
+			t.Fatalf("at %v the render tick went back from %.3f to %.3f", frame, prev, tick)
+		}
+		prev = tick
+		// Behind the server by Delay plus the average latency of 40ms.
+		if frame > time.Second {
+			want := frame.Seconds()*rate - (140*time.Millisecond).Seconds()*rate
+			if math.Abs(tick-want) > 0.5 {
+				t.Fatalf("at %v the render tick is %.3f, want about %.3f", frame, tick, want)
+			}
+		}
+	}
+}
+
+func TestTickRateRequired(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewBuffer accepted a zero TickRate")
+		}
+	}()
+	NewBuffer(lerpFloat, Config{})
+}
@@ -0,0 +1,65 @@
+package snapshot
+
+import "sync"
+
+type pendingInput[I any] struct {
+	tick  uint32
+	input I
+}
+
+// Predictor applies local inputs immediately and, when the server's state
+// for a tick arrives, rewinds to it and replays the inputs the server has
+// not processed yet.
+type Predictor[S, I any] struct {
+	apply func(state *S, input I) *S
+
+	mu      sync.Mutex
+	state   *S
+	pending []pendingInput[I]
+}
+
+// NewPredictor starts from initial. apply must be the same deterministic
+// step the server runs for an input; it may modify and return state.
+func NewPredictor[S, I any](initial *S, apply func(state *S, input I) *S) *Predictor[S, I] {
+	return &Predictor[S, I]{apply: apply, state: initial}
+}
+
+// Apply records input for tick and returns the predicted state.
+func (p *Predictor[S, I]) Apply(tick uint32, input I) *S {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, pendingInput[I]{tick: tick, input: input})
+	p.state = p.apply(p.state, input)
+	return p.state
+}
+
+// Reconcile takes the server's state after processing inputs up to and
+// including ackTick, drops those inputs and replays the rest on top of it.
+func (p *Predictor[S, I]) Reconcile(ackTick uint32, authoritative *S) *S {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := 0
+	for i < len(p.pending) && p.pending[i].tick <= ackTick {
+		i++
+	}
+	p.pending = append(p.pending[:0], p.pending[i:]...)
+	p.state = authoritative
+	for _, in := range p.pending {
+		p.state = p.apply(p.state, in.input)
+	}
+	return p.state
+}
+
+// State returns the current predicted state.
+func (p *Predictor[S, I]) State() *S {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// Pending returns the number of inputs not yet acknowledged by the server.
+func (p *Predictor[S, I]) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
@@ -0,0 +1,632 @@
+// Package udp is a connection layer for sending generated BitPacker types
+// over UDP. Every packet carries a sequence number plus an ack and a 32-bit
+// ack bitfield for the peer's packets, which drives resends on the
+// ReliableOrdered channel. Messages larger than one packet are fragmented
+// and reassembled on both channels.
+//
+// There is no handshake: a Conn is a peer address plus a shared ProtocolID,
+// and it times out when the peer stops sending.
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"bit-parser/runtime/bitpacker"
+)
+
+// Channel selects the delivery guarantees for a message.
+type Channel uint8
+
+const (
+	// Unreliable messages may be lost or arrive out of order.
+	Unreliable Channel = iota
+	// ReliableOrdered messages are resent until acked and delivered in
+	// the order they were sent.
+	ReliableOrdered
+)
+
+var (
+	ErrTimeout         = errors.New("udp: connection timed out")
+	ErrWindowFull      = errors.New("udp: reliable send window full")
+	ErrMessageTooLarge = errors.New("udp: message too large")
+	ErrNoRegistry      = errors.New("udp: no Registry configured")
+)
+
+type Config struct {
+	// ProtocolID is written into every packet; packets with another ID
+	// are dropped.
+	ProtocolID uint32
+	// MaxPacketSize bounds each datagram, including headers. Default 1200;
+	// anything below 27 (the headers plus one byte) is raised to 27.
+	MaxPacketSize int
+	// ResendInterval is the shortest time a reliable message waits for an
+	// ack before it is sent again. Once the RTT is measured the wait is
+	// RTT plus four times its mean deviation, if that is longer.
+	// Default 100ms.
+	ResendInterval time.Duration
+	// KeepAlive is the longest a Conn stays silent; an ack-only packet
+	// is sent when nothing else was. Default 250ms.
+	KeepAlive time.Duration
+	// Timeout closes the Conn with ErrTimeout when nothing has been
+	// received for this long. Default 10s.
+	Timeout time.Duration
+	// SendWindow caps unacked reliable messages (fragments count
+	// individually); Send returns ErrWindowFull beyond it. Default 1024,
+	// at most 16384.
+	SendWindow int
+	// Registry, when set, is used by SendMessage and RecvMessage to
+	// multiplex message types.
+	Registry *bitpacker.Registry
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxPacketSize <= 0 {
+		c.MaxPacketSize = 1200
+	}
+	c.MaxPacketSize = max(c.MaxPacketSize, minPacketSize)
+	if c.ResendInterval <= 0 {
+		c.ResendInterval = 100 * time.Millisecond
+	}
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = 250 * time.Millisecond
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.SendWindow <= 0 {
+		c.SendWindow = 1024
+	}
+	if c.SendWindow > 16384 {
+		c.SendWindow = 16384
+	}
+	return c
+}
+
+// Stats are packet-level counters for a Conn.
+type Stats struct {
+	PacketsSent     uint64
+	PacketsReceived uint64
+	PacketsAcked    uint64
+	// PacketsLost counts sent packets that were still unacked when the
+	// peer acked one more than 32 packets newer.
+	PacketsLost uint64
+	RTT         time.Duration
+}
+
+const (
+	sentRingSize    = 1024
+	fragmentTimeout = time.Second
+	minPacketSize   = packetHeaderSize + entryHeaderMax + 1
+	// ackEvery bounds how many packets with unreliable entries go unacked
+	// before an ack-only packet is sent; reliable entries are acked at
+	// once. It stays well inside the 32 packets an ack bitfield covers.
+	ackEvery = 16
+	// resendBurst caps the packets of resent entries one flush sends, so
+	// a stalled peer does not turn every Send into a burst of resends.
+	resendBurst = 4
+)
+
+type sentPacket struct {
+	seq    uint16
+	valid  bool
+	acked  bool
+	sentAt time.Time
+	msgs   []uint16
+}
+
+type receivedPacket struct {
+	seq   uint16
+	valid bool
+}
+
+type pendingEntry struct {
+	entry
+	lastSent time.Time
+}
+
+type fragAssembly struct {
+	parts   [][]byte
+	got     int
+	created time.Time
+}
+
+// Conn is one peer. It is safe for concurrent use.
+type Conn struct {
+	cfg       Config
+	pc        net.PacketConn
+	remote    net.Addr
+	owner     *Listener
+	fragSize  int
+	closeOnce sync.Once
+	done      chan struct{}
+	ready     chan struct{}
+
+	mu          sync.Mutex
+	err         error
+	localSeq    uint16
+	remoteSeq   uint16
+	ackBits     uint32
+	receivedAny bool
+	ackDirty    bool
+	unacked     int
+	lostCheck   uint16
+	rttVar      time.Duration
+	sent        [sentRingSize]sentPacket
+	received    [sentRingSize]receivedPacket
+	lastSend    time.Time
+	lastRecv    time.Time
+	stats       Stats
+
+	// ReliableOrdered channel
+	sendBase   uint16
+	sendSeq    uint16
+	pending    map[uint16]*pendingEntry
+	recvSeq    uint16
+	recvBuf    map[uint16]entry
+	assembling []byte
+
+	// Unreliable channel
+	outbox     []entry
+	nextFragID uint16
+	frags      map[uint16]*fragAssembly
+
+	inbox [][]byte
+}
+
+func newConn(pc net.PacketConn, remote net.Addr, cfg Config, owner *Listener) *Conn {
+	now := time.Now()
+	c := &Conn{
+		cfg:      cfg,
+		pc:       pc,
+		remote:   remote,
+		owner:    owner,
+		fragSize: cfg.MaxPacketSize - packetHeaderSize - entryHeaderMax,
+		done:     make(chan struct{}),
+		ready:    make(chan struct{}, 1),
+		lastSend: now,
+		lastRecv: now,
+		pending:  make(map[uint16]*pendingEntry),
+		recvBuf:  make(map[uint16]entry),
+		frags:    make(map[uint16]*fragAssembly),
+	}
+	go c.tickLoop()
+	return c
+}
+
+// Dial starts a Conn to remote over pc. The Conn takes ownership of pc and
+// closes it on Close.
+func Dial(pc net.PacketConn, remote net.Addr, cfg Config) *Conn {
+	c := newConn(pc, remote, cfg.withDefaults(), nil)
+	go c.readLoop()
+	return c
+}
+
+// DialUDP opens a UDP socket on an ephemeral port and dials addr.
+func DialUDP(addr string, cfg Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	return Dial(pc, raddr, cfg), nil
+}
+
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) LocalAddr() net.Addr { return c.pc.LocalAddr() }
+
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Send queues data on ch and flushes it immediately. data is copied.
+func (c *Conn) Send(ch Channel, data []byte) error {
+	n := (len(data) + c.fragSize - 1) / c.fragSize
+	if n == 0 {
+		n = 1
+	}
+	if n > maxFragments {
+		return ErrMessageTooLarge
+	}
+	data = append([]byte(nil), data...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	var fragID uint16
+	if ch == Unreliable && n > 1 {
+		fragID = c.nextFragID
+		c.nextFragID++
+	} else if ch == ReliableOrdered && int(c.sendSeq-c.sendBase)+n > c.cfg.SendWindow {
+		return ErrWindowFull
+	}
+	for i := 0; i < n; i++ {
+		e := entry{data: data[min(i*c.fragSize, len(data)):min((i+1)*c.fragSize, len(data))]}
+		if n > 1 {
+			e.flags |= entryFragment
+			e.fragID, e.fragIndex, e.fragCount = fragID, uint8(i), uint8(n)
+		}
+		if ch == ReliableOrdered {
+			e.flags |= entryReliable
+			e.seq = c.sendSeq
+			c.pending[c.sendSeq] = &pendingEntry{entry: e}
+			c.sendSeq++
+		} else {
+			c.outbox = append(c.outbox, e)
+		}
+	}
+	return c.flushLocked(time.Now())
+}
+
+// SendMessage sends msg.Encode(), prefixed with its type ID when
+// Config.Registry is set.
+func (c *Conn) SendMessage(ch Channel, msg bitpacker.Encoder) error {
+	if c.cfg.Registry == nil {
+		return c.Send(ch, msg.Encode())
+	}
+	data, err := c.cfg.Registry.EncodeAny(msg)
+	if err != nil {
+		return err
+	}
+	return c.Send(ch, data)
+}
+
+// Recv returns the next message from either channel.
+func (c *Conn) Recv(ctx context.Context) ([]byte, error) {
+	for {
+		c.mu.Lock()
+		if len(c.inbox) > 0 {
+			data := c.inbox[0]
+			c.inbox[0] = nil
+			c.inbox = c.inbox[1:]
+			c.mu.Unlock()
+			return data, nil
+		}
+		err := c.err
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-c.ready:
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// RecvMessage receives a message sent with SendMessage and decodes it
+// through Config.Registry.
+func (c *Conn) RecvMessage(ctx context.Context) (bitpacker.Encoder, error) {
+	if c.cfg.Registry == nil {
+		return nil, ErrNoRegistry
+	}
+	data, err := c.Recv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.cfg.Registry.DecodeAny(data)
+}
+
+// Receive is Recv followed by a generated Decode<T>, for connections that
+// carry a single message type without a Registry:
+//
+//	world, err := udp.Receive(ctx, conn, gen.DecodeWorldState)
+func Receive[T any](ctx context.Context, c *Conn, decode func([]byte) (*T, error)) (*T, error) {
+	data, err := c.Recv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
+
+func (c *Conn) Close() error {
+	c.closeWithErr(net.ErrClosed)
+	return nil
+}
+
+func (c *Conn) closeWithErr(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+		if c.owner != nil {
+			c.owner.remove(c)
+		} else {
+			c.pc.Close()
+		}
+	})
+}
+
+func (c *Conn) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := c.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				c.closeWithErr(net.ErrClosed)
+				return
+			}
+			continue
+		}
+		if addr.String() != c.remote.String() {
+			continue
+		}
+		c.handlePacket(append([]byte(nil), buf[:n]...), time.Now())
+	}
+}
+
+func (c *Conn) tickLoop() {
+	t := time.NewTicker(min(c.cfg.ResendInterval, c.cfg.KeepAlive) / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-t.C:
+			c.mu.Lock()
+			if now.Sub(c.lastRecv) > c.cfg.Timeout {
+				c.mu.Unlock()
+				c.closeWithErr(ErrTimeout)
+				return
+			}
+			for id, f := range c.frags {
+				if now.Sub(f.created) > fragmentTimeout {
+					delete(c.frags, id)
+				}
+			}
+			c.flushLocked(now)
+			c.mu.Unlock()
+		}
+	}
+}
+
+// handlePacket processes one datagram from the peer. pkt must not be reused
+// by the caller.
+func (c *Conn) handlePacket(pkt []byte, now time.Time) {
+	h, entries, err := readPacket(pkt)
+	if err != nil || h.protocol != c.cfg.ProtocolID {
+		return
+	}
+	c.mu.Lock()
+	c.lastRecv = now
+	c.stats.PacketsReceived++
+	if h.flags&headerAckValid != 0 {
+		c.processAcks(h.ack, h.ackBits, now)
+	}
+	if c.recordReceived(h.seq) && len(entries) > 0 {
+		c.ackDirty = true
+		c.unacked++
+		reliable := false
+		for _, e := range entries {
+			c.receiveEntry(e, now)
+			reliable = reliable || e.flags&entryReliable != 0
+		}
+		if reliable || c.unacked >= ackEvery {
+			c.flushLocked(now)
+		}
+	}
+	delivered := len(c.inbox) > 0
+	c.mu.Unlock()
+	if delivered {
+		select {
+		case c.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// recordReceived updates the ack state for seq and reports whether the
+// packet is new. Duplicates are detected over the last sentRingSize
+// sequence numbers, well beyond the 32 carried in the ack bitfield.
+func (c *Conn) recordReceived(seq uint16) bool {
+	if !c.receivedAny {
+		c.receivedAny = true
+		c.remoteSeq = seq
+	} else if seqGreater(seq, c.remoteSeq) {
+		shift := uint32(seq - c.remoteSeq)
+		if shift > 32 {
+			c.ackBits = 0
+		} else {
+			c.ackBits = c.ackBits<<shift | 1<<(shift-1)
+		}
+		c.remoteSeq = seq
+	} else if d := uint32(c.remoteSeq - seq); d >= sentRingSize {
+		return false
+	} else if d > 0 && d <= 32 {
+		c.ackBits |= 1 << (d - 1)
+	}
+	slot := &c.received[seq%sentRingSize]
+	if slot.valid && slot.seq == seq {
+		return false
+	}
+	*slot = receivedPacket{seq: seq, valid: true}
+	return true
+}
+
+func (c *Conn) processAcks(ack uint16, bits uint32, now time.Time) {
+	for i := 0; i <= 32; i++ {
+		if i > 0 && bits&(1<<(i-1)) == 0 {
+			continue
+		}
+		seq := ack - uint16(i)
+		p := &c.sent[seq%sentRingSize]
+		if !p.valid || p.seq != seq || p.acked {
+			continue
+		}
+		p.acked = true
+		c.stats.PacketsAcked++
+		sample := now.Sub(p.sentAt)
+		if c.stats.RTT == 0 {
+			c.stats.RTT, c.rttVar = sample, sample/2
+		} else {
+			c.rttVar += (abs(sample-c.stats.RTT) - c.rttVar) / 4
+			c.stats.RTT += (sample - c.stats.RTT) / 8
+		}
+		for _, m := range p.msgs {
+			delete(c.pending, m)
+		}
+	}
+	for c.sendBase != c.sendSeq {
+		if _, ok := c.pending[c.sendBase]; ok {
+			break
+		}
+		c.sendBase++
+	}
+	// Packets older than the bitfield reaches can no longer be acked.
+	for seqGreater(ack-32, c.lostCheck) {
+		if p := &c.sent[c.lostCheck%sentRingSize]; p.valid && p.seq == c.lostCheck && !p.acked {
+			c.stats.PacketsLost++
+		}
+		c.lostCheck++
+	}
+}
+
+// resendTimeout is how long a reliable entry waits for its ack.
+func (c *Conn) resendTimeout() time.Duration {
+	return max(c.cfg.ResendInterval, c.stats.RTT+4*c.rttVar)
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func (c *Conn) receiveEntry(e entry, now time.Time) {
+	if e.flags&entryReliable == 0 {
+		if e.flags&entryFragment == 0 {
+			c.inbox = append(c.inbox, e.data)
+			return
+		}
+		f := c.frags[e.fragID]
+		if f == nil || len(f.parts) != int(e.fragCount) {
+			f = &fragAssembly{parts: make([][]byte, e.fragCount), created: now}
+			c.frags[e.fragID] = f
+		}
+		if f.parts[e.fragIndex] == nil {
+			f.parts[e.fragIndex] = e.data
+			f.got++
+		}
+		if f.got == len(f.parts) {
+			delete(c.frags, e.fragID)
+			var msg []byte
+			for _, p := range f.parts {
+				msg = append(msg, p...)
+			}
+			c.inbox = append(c.inbox, msg)
+		}
+		return
+	}
+
+	if e.seq-c.recvSeq >= 32768 {
+		return // already delivered
+	}
+	if _, ok := c.recvBuf[e.seq]; ok {
+		return
+	}
+	c.recvBuf[e.seq] = e
+	for {
+		next, ok := c.recvBuf[c.recvSeq]
+		if !ok {
+			return
+		}
+		delete(c.recvBuf, c.recvSeq)
+		c.recvSeq++
+		if next.flags&entryFragment == 0 {
+			c.inbox = append(c.inbox, next.data)
+			continue
+		}
+		c.assembling = append(c.assembling, next.data...)
+		if next.fragIndex == next.fragCount-1 {
+			c.inbox = append(c.inbox, c.assembling)
+			c.assembling = nil
+		}
+	}
+}
+
+// flushLocked sends queued unreliable entries and due reliable ones, packed
+// into as few packets as fit. Resends are limited to resendBurst packets'
+// worth, oldest first. With nothing to send it still sends an ack-only
+// packet when acks are owed or KeepAlive has elapsed.
+func (c *Conn) flushLocked(now time.Time) error {
+	var entries []*entry
+	timeout := c.resendTimeout()
+	budget := resendBurst * (c.cfg.MaxPacketSize - packetHeaderSize)
+	resends := 0
+	for s := c.sendBase; s != c.sendSeq; s++ {
+		p, ok := c.pending[s]
+		if !ok {
+			continue
+		}
+		if !p.lastSent.IsZero() {
+			if now.Sub(p.lastSent) < timeout || budget < p.size() || resends == resendBurst*maxEntries {
+				continue
+			}
+			budget -= p.size()
+			resends++
+		}
+		p.lastSent = now
+		entries = append(entries, &p.entry)
+	}
+	for i := range c.outbox {
+		entries = append(entries, &c.outbox[i])
+	}
+	c.outbox = c.outbox[:0]
+	if len(entries) == 0 && !c.ackDirty && now.Sub(c.lastSend) < c.cfg.KeepAlive {
+		return nil
+	}
+
+	for {
+		size, count := packetHeaderSize, 0
+		for count < len(entries) && count < maxEntries && size+entries[count].size() <= c.cfg.MaxPacketSize {
+			size += entries[count].size()
+			count++
+		}
+		if err := c.sendPacket(entries[:count], now); err != nil {
+			return err
+		}
+		entries = entries[count:]
+		if len(entries) == 0 {
+			return nil
+		}
+	}
+}
+
+func (c *Conn) sendPacket(entries []*entry, now time.Time) error {
+	h := header{protocol: c.cfg.ProtocolID, seq: c.localSeq}
+	if c.receivedAny {
+		h.flags |= headerAckValid
+		h.ack, h.ackBits = c.remoteSeq, c.ackBits
+	}
+	pkt := appendHeader(make([]byte, 0, c.cfg.MaxPacketSize), h, uint8(len(entries)))
+	slot := &c.sent[c.localSeq%sentRingSize]
+	*slot = sentPacket{seq: c.localSeq, valid: true, sentAt: now, msgs: slot.msgs[:0]}
+	for _, e := range entries {
+		pkt = appendEntry(pkt, e)
+		if e.flags&entryReliable != 0 {
+			slot.msgs = append(slot.msgs, e.seq)
+		}
+	}
+	c.localSeq++
+	c.lastSend = now
+	c.ackDirty = false
+	c.unacked = 0
+	c.stats.PacketsSent++
+	_, err := c.pc.WriteTo(pkt, c.remote)
+	return err
+}
@@ -0,0 +1,252 @@
+package udp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	gen "bit-parser/generated/go_bench/go"
+	"bit-parser/runtime/bitpacker"
+)
+
+// pair connects a client Conn to a Listener over loopback, with sim applied
+// to the datagrams each side writes, and returns both ends.
+func pair(t *testing.T, cfg Config, sim SimConfig) (client, server *Conn) {
+	t.Helper()
+	lpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := Listen(Simulate(lpc, sim), cfg)
+	client = Dial(Simulate(cpc, SimConfig{
+		Loss: sim.Loss, Duplicate: sim.Duplicate, Latency: sim.Latency, Jitter: sim.Jitter, Seed: sim.Seed + 1,
+	}), lpc.LocalAddr(), cfg)
+	t.Cleanup(func() {
+		client.Close()
+		l.Close()
+	})
+
+	// The server side only exists once a packet has arrived.
+	if err := client.Send(ReliableOrdered, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if server, err = l.Accept(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := server.Recv(ctx); err != nil || string(data) != "hello" {
+		t.Fatalf("first message: %q, %v", data, err)
+	}
+	return client, server
+}
+
+func recvN(t *testing.T, c *Conn, n int, timeout time.Duration) [][]byte {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var msgs [][]byte
+	for len(msgs) < n {
+		data, err := c.Recv(ctx)
+		if err != nil {
+			t.Fatalf("after %d of %d messages: %v", len(msgs), n, err)
+		}
+		msgs = append(msgs, data)
+	}
+	return msgs
+}
+
+func TestReliableOrderedUnderLoss(t *testing.T) {
+	client, server := pair(t, Config{ProtocolID: 7}, SimConfig{
+		Loss: 0.2, Duplicate: 0.1, Jitter: 20 * time.Millisecond, Seed: 1,
+	})
+	const n = 200
+	for i := 0; i < n; i++ {
+		if err := client.Send(ReliableOrdered, []byte(fmt.Sprintf("msg %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, data := range recvN(t, server, n, 10*time.Second) {
+		if want := fmt.Sprintf("msg %d", i); string(data) != want {
+			t.Fatalf("message %d is %q, want %q", i, data, want)
+		}
+	}
+}
+
+func TestUnreliableFragmentation(t *testing.T) {
+	client, server := pair(t, Config{ProtocolID: 7, MaxPacketSize: 100}, SimConfig{})
+	big := make([]byte, 5000)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	if err := client.Send(Unreliable, big); err != nil {
+		t.Fatal(err)
+	}
+	if got := recvN(t, server, 1, 5*time.Second)[0]; !bytes.Equal(got, big) {
+		t.Fatalf("reassembled %d bytes, want %d", len(got), len(big))
+	}
+	if err := client.Send(Unreliable, make([]byte, 256*100)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("oversized message: %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestDuplicatesSuppressed(t *testing.T) {
+	client, server := pair(t, Config{ProtocolID: 7}, SimConfig{Duplicate: 1})
+	const n = 50
+	for i := 0; i < n; i++ {
+		client.Send(Unreliable, []byte{byte(i)})
+		client.Send(ReliableOrdered, []byte{byte(i), 'r'})
+	}
+	recvN(t, server, 2*n, 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if data, err := server.Recv(ctx); err == nil {
+		t.Fatalf("extra message %q", data)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// Nothing answers on silent.
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Dial(pc, silent.LocalAddr(), Config{ProtocolID: 7, Timeout: 200 * time.Millisecond})
+	defer c.Close()
+	c.Send(ReliableOrdered, []byte("anyone?"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Recv(ctx); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("timed out after %v", d)
+	}
+	if err := c.Send(Unreliable, []byte("x")); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Send after timeout: %v", err)
+	}
+}
+
+func TestPacketsPerMessage(t *testing.T) {
+	client, server := pair(t, Config{ProtocolID: 7}, SimConfig{})
+	const n = 200
+	base := client.Stats().PacketsSent + server.Stats().PacketsSent
+	for i := 0; i < n; i++ {
+		if err := client.Send(ReliableOrdered, bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recvN(t, server, n, 5*time.Second)
+	time.Sleep(50 * time.Millisecond)
+
+	cs, ss := client.Stats(), server.Stats()
+	// One data packet per message and at most one ack for it.
+	if sent := cs.PacketsSent + ss.PacketsSent - base; sent > 2*n+10 {
+		t.Errorf("%d packets for %d messages", sent, n)
+	}
+	if cs.PacketsLost != 0 || ss.PacketsLost != 0 {
+		t.Errorf("PacketsLost %d/%d on a clean link", cs.PacketsLost, ss.PacketsLost)
+	}
+
+	// An idle connection only sends keep-alives.
+	before := cs.PacketsSent + ss.PacketsSent
+	time.Sleep(time.Second)
+	idle := client.Stats().PacketsSent + server.Stats().PacketsSent - before
+	if limit := uint64(2 * (time.Second/client.cfg.KeepAlive + 1)); idle > limit {
+		t.Errorf("%d packets in 1s idle, want at most %d", idle, limit)
+	}
+}
+
+func TestTinyMaxPacketSize(t *testing.T) {
+	client, server := pair(t, Config{ProtocolID: 7, MaxPacketSize: 10}, SimConfig{})
+	if err := client.Send(ReliableOrdered, []byte("still fits")); err != nil {
+		t.Fatal(err)
+	}
+	if got := recvN(t, server, 1, 5*time.Second)[0]; string(got) != "still fits" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	reg := bitpacker.NewRegistry()
+	bitpacker.Register(reg, 1, gen.DecodeVec3)
+	bitpacker.Register(reg, 2, gen.DecodeWorldState)
+	client, server := pair(t, Config{ProtocolID: 7, Registry: reg}, SimConfig{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	world := &gen.WorldState{World_id: 3, Seed: "abc", Loot_table: []gen.Item{{Id: 1, Name: "sword"}}}
+	if err := client.SendMessage(ReliableOrdered, world); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SendMessage(ReliableOrdered, &gen.Vec3{X: 1, Y: 2, Z: 3}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := server.RecvMessage(ctx)
+	if w, ok := msg.(*gen.WorldState); err != nil || !ok || w.World_id != 3 || w.Seed != "abc" || len(w.Loot_table) != 1 || w.Loot_table[0].Name != "sword" {
+		t.Fatalf("first message: %#v, %v", msg, err)
+	}
+	msg, err = server.RecvMessage(ctx)
+	if v, ok := msg.(*gen.Vec3); err != nil || !ok || *v != (gen.Vec3{X: 1, Y: 2, Z: 3}) {
+		t.Fatalf("second message: %#v, %v", msg, err)
+	}
+	if err := client.SendMessage(ReliableOrdered, &gen.Item{}); !errors.Is(err, bitpacker.ErrUnregistered) {
+		t.Fatalf("unregistered type: %v, want ErrUnregistered", err)
+	}
+}
+
+func TestReceiveWithoutRegistry(t *testing.T) {
+	client, server := pair(t, Config{ProtocolID: 7}, SimConfig{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := server.RecvMessage(ctx); !errors.Is(err, ErrNoRegistry) {
+		t.Fatalf("RecvMessage: %v, want ErrNoRegistry", err)
+	}
+	// Without a Registry SendMessage sends the bare Encode().
+	if err := client.SendMessage(ReliableOrdered, &gen.Vec3{X: -4, Z: 9}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := Receive(ctx, server, gen.DecodeVec3)
+	if err != nil || *v != (gen.Vec3{X: -4, Z: 9}) {
+		t.Fatalf("Receive: %+v, %v", v, err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	client, server := pair(t, Config{ProtocolID: 7}, SimConfig{Latency: latency})
+
+	start := time.Now()
+	if err := client.Send(ReliableOrdered, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if got := recvN(t, server, 1, 5*time.Second)[0]; string(got) != "ping" {
+		t.Fatalf("got %q", got)
+	}
+	if d := time.Since(start); d < latency {
+		t.Fatalf("delivered after %v, want at least %v", d, latency)
+	}
+
+	// Every packet is delayed both ways, so the measured RTT is about twice
+	// the latency.
+	time.Sleep(4 * latency)
+	if rtt := client.Stats().RTT; rtt < 2*latency || rtt > 2*latency+100*time.Millisecond {
+		t.Fatalf("RTT %v with %v latency each way", rtt, latency)
+	}
+}
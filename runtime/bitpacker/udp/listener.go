@@ -0,0 +1,130 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const acceptBacklog = 64
+
+// Listener demultiplexes one socket into a Conn per remote address. A new
+// Conn is created by the first valid packet from an address.
+type Listener struct {
+	pc        net.PacketConn
+	cfg       Config
+	accept    chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.Mutex
+	conns map[string]*Conn
+}
+
+// Listen serves Conns over pc. The Listener takes ownership of pc and
+// closes it on Close.
+func Listen(pc net.PacketConn, cfg Config) *Listener {
+	l := &Listener{
+		pc:     pc,
+		cfg:    cfg.withDefaults(),
+		accept: make(chan *Conn, acceptBacklog),
+		done:   make(chan struct{}),
+		conns:  make(map[string]*Conn),
+	}
+	go l.readLoop()
+	return l
+}
+
+// ListenUDP opens a UDP socket on addr and listens on it.
+func ListenUDP(addr string, cfg Config) (*Listener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return Listen(pc, cfg), nil
+}
+
+func (l *Listener) Addr() net.Addr { return l.pc.LocalAddr() }
+
+// Accept returns the next new peer.
+func (l *Listener) Accept(ctx context.Context) (*Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the socket and every Conn accepted from it.
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.pc.Close()
+		l.mu.Lock()
+		conns := make([]*Conn, 0, len(l.conns))
+		for _, c := range l.conns {
+			conns = append(conns, c)
+		}
+		l.mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	return err
+}
+
+func (l *Listener) remove(c *Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[c.remote.String()] == c {
+		delete(l.conns, c.remote.String())
+	}
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				l.Close()
+				return
+			}
+			continue
+		}
+		pkt := append([]byte(nil), buf[:n]...)
+		if h, _, err := readPacket(pkt); err != nil || h.protocol != l.cfg.ProtocolID {
+			continue
+		}
+
+		key := addr.String()
+		l.mu.Lock()
+		c, ok := l.conns[key]
+		if !ok {
+			select {
+			case <-l.done:
+				l.mu.Unlock()
+				return
+			default:
+			}
+			c = newConn(l.pc, addr, l.cfg, l)
+			select {
+			case l.accept <- c:
+				l.conns[key] = c
+			default:
+				// Backlog full: drop the packet, the peer will retry.
+				l.mu.Unlock()
+				c.closeWithErr(net.ErrClosed)
+				continue
+			}
+		}
+		l.mu.Unlock()
+		c.handlePacket(pkt, time.Now())
+	}
+}
@@ -0,0 +1,150 @@
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Packet layout (little-endian):
+//
+//	uint32 protocol id
+//	uint16 sequence
+//	uint16 ack           most recent sequence received from the peer
+//	uint32 ack bits      bit i set = ack-1-i was received too
+//	uint8  flags         headerAckValid once anything has been received
+//	uint8  entry count
+//	entries...
+//
+// Each entry is:
+//
+//	uint8  flags         entryReliable, entryFragment
+//	uint16 message seq   reliable entries only
+//	uint16 fragment id   fragments only (unreliable channel)
+//	uint8  index, count  fragments only
+//	varint length, bytes
+const (
+	packetHeaderSize = 14
+	entryHeaderMax   = 1 + 2 + 4 + binary.MaxVarintLen32
+	maxEntries       = 255
+	maxFragments     = 255
+
+	headerAckValid = 1 << 0
+
+	entryReliable = 1 << 0
+	entryFragment = 1 << 1
+)
+
+var errMalformed = errors.New("malformed packet")
+
+type header struct {
+	protocol uint32
+	seq      uint16
+	ack      uint16
+	ackBits  uint32
+	flags    byte
+}
+
+type entry struct {
+	flags     byte
+	seq       uint16
+	fragID    uint16
+	fragIndex uint8
+	fragCount uint8
+	data      []byte
+}
+
+func (e *entry) size() int {
+	n := 1 + varintLen(len(e.data)) + len(e.data)
+	if e.flags&entryReliable != 0 {
+		n += 2
+	}
+	if e.flags&entryFragment != 0 {
+		n += 4
+	}
+	return n
+}
+
+func appendHeader(b []byte, h header, count uint8) []byte {
+	b = binary.LittleEndian.AppendUint32(b, h.protocol)
+	b = binary.LittleEndian.AppendUint16(b, h.seq)
+	b = binary.LittleEndian.AppendUint16(b, h.ack)
+	b = binary.LittleEndian.AppendUint32(b, h.ackBits)
+	return append(b, h.flags, count)
+}
+
+func appendEntry(b []byte, e *entry) []byte {
+	b = append(b, e.flags)
+	if e.flags&entryReliable != 0 {
+		b = binary.LittleEndian.AppendUint16(b, e.seq)
+	}
+	if e.flags&entryFragment != 0 {
+		b = binary.LittleEndian.AppendUint16(b, e.fragID)
+		b = append(b, e.fragIndex, e.fragCount)
+	}
+	b = binary.AppendUvarint(b, uint64(len(e.data)))
+	return append(b, e.data...)
+}
+
+// readPacket parses a datagram. Entry data aliases b.
+func readPacket(b []byte) (header, []entry, error) {
+	var h header
+	if len(b) < packetHeaderSize {
+		return h, nil, errMalformed
+	}
+	h.protocol = binary.LittleEndian.Uint32(b[0:])
+	h.seq = binary.LittleEndian.Uint16(b[4:])
+	h.ack = binary.LittleEndian.Uint16(b[6:])
+	h.ackBits = binary.LittleEndian.Uint32(b[8:])
+	h.flags = b[12]
+	count := int(b[13])
+	b = b[packetHeaderSize:]
+
+	entries := make([]entry, count)
+	for i := range entries {
+		e := &entries[i]
+		if len(b) < 1 {
+			return h, nil, errMalformed
+		}
+		e.flags = b[0]
+		b = b[1:]
+		if e.flags&entryReliable != 0 {
+			if len(b) < 2 {
+				return h, nil, errMalformed
+			}
+			e.seq = binary.LittleEndian.Uint16(b)
+			b = b[2:]
+		}
+		if e.flags&entryFragment != 0 {
+			if len(b) < 4 {
+				return h, nil, errMalformed
+			}
+			e.fragID = binary.LittleEndian.Uint16(b)
+			e.fragIndex, e.fragCount = b[2], b[3]
+			b = b[4:]
+			if e.fragCount == 0 || e.fragIndex >= e.fragCount {
+				return h, nil, errMalformed
+			}
+		}
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return h, nil, errMalformed
+		}
+		e.data = b[n : n+int(l)]
+		b = b[n+int(l):]
+	}
+	return h, entries, nil
+}
+
+func varintLen(n int) int {
+	l := 1
+	for n >= 0x80 {
+		n >>= 7
+		l++
+	}
+	return l
+}
+
+// seqGreater compares 16-bit sequence numbers with wrap-around.
+func seqGreater(a, b uint16) bool {
+	return (a > b && a-b <= 32768) || (a < b && b-a > 32768)
+}
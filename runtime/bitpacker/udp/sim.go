@@ -0,0 +1,75 @@
+package udp
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// SimConfig describes the impairments a SimulatedConn applies to the
+// datagrams written through it.
+type SimConfig struct {
+	// Loss is the probability in [0, 1] that a datagram is dropped.
+	Loss float64
+	// Duplicate is the probability in [0, 1] that a datagram is sent twice.
+	Duplicate float64
+	// Latency delays every datagram.
+	Latency time.Duration
+	// Jitter adds a uniform extra delay in [0, Jitter), which also
+	// reorders datagrams.
+	Jitter time.Duration
+	// Seed makes the loss/jitter sequence reproducible.
+	Seed uint64
+}
+
+// SimulatedConn wraps a net.PacketConn and degrades outgoing traffic, so
+// Conns can be exercised over loopback as if over a bad network. Wrap both
+// ends to impair both directions.
+type SimulatedConn struct {
+	net.PacketConn
+	cfg SimConfig
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func Simulate(pc net.PacketConn, cfg SimConfig) *SimulatedConn {
+	return &SimulatedConn{
+		PacketConn: pc,
+		cfg:        cfg,
+		rng:        rand.New(rand.NewPCG(cfg.Seed, cfg.Seed^0x9e3779b97f4a7c15)),
+	}
+}
+
+func (s *SimulatedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	s.mu.Lock()
+	drop := s.rng.Float64() < s.cfg.Loss
+	copies := 1
+	if s.rng.Float64() < s.cfg.Duplicate {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = s.cfg.Latency
+		if s.cfg.Jitter > 0 {
+			delays[i] += time.Duration(s.rng.Int64N(int64(s.cfg.Jitter)))
+		}
+	}
+	s.mu.Unlock()
+
+	if drop {
+		return len(p), nil
+	}
+	buf := append([]byte(nil), p...)
+	for _, d := range delays {
+		if d <= 0 {
+			if _, err := s.PacketConn.WriteTo(buf, addr); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		time.AfterFunc(d, func() { s.PacketConn.WriteTo(buf, addr) })
+	}
+	return len(p), nil
+}